@@ -0,0 +1,60 @@
+package backoff
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	// DefaultAttemptHeader is the conventional header for the attempt number.
+	DefaultAttemptHeader = "X-Retry-Attempt"
+	// DefaultRequestIDHeader is the conventional header for the request ID
+	// shared by all attempts.
+	DefaultRequestIDHeader = "X-Request-Id"
+)
+
+// AttemptInfo describes one attempt of an Execute call. It is available to
+// http.RoundTripper middleware through AttemptFromContext.
+type AttemptInfo struct {
+	// Attempt is the attempt number, from 1.
+	Attempt int
+
+	// RequestID is shared by all attempts of an Execute call. It is taken
+	// from the request ID header if the request has one.
+	RequestID string
+
+	// Delay is the wait before this attempt, zero for the first one.
+	Delay time.Duration
+
+	// Reason is why the previous attempt was retried, nil for the first one.
+	Reason error
+
+	// Route is the name of the route the request matched, see WithRoute.
+	Route string
+}
+
+// Retry reports whether the attempt is a retry.
+func (a AttemptInfo) Retry() bool {
+	return a.Attempt > 1
+}
+
+type attemptKey struct{}
+
+// AttemptFromContext returns the attempt the request context belongs to.
+func AttemptFromContext(ctx context.Context) (AttemptInfo, bool) {
+	info, ok := ctx.Value(attemptKey{}).(AttemptInfo)
+	return info, ok
+}
+
+func withAttempt(ctx context.Context, info AttemptInfo) context.Context {
+	return context.WithValue(ctx, attemptKey{}, info)
+}
+
+// newRequestID returns a random 128-bit hex request ID.
+func newRequestID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
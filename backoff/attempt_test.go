@@ -0,0 +1,158 @@
+package backoff
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingTransport records the attempt of every request it sends.
+type recordingTransport struct {
+	mu       sync.Mutex
+	attempts []AttemptInfo
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	info, ok := AttemptFromContext(req.Context())
+	if !ok {
+		return nil, http.ErrNotSupported
+	}
+
+	t.mu.Lock()
+	t.attempts = append(t.attempts, info)
+	t.mu.Unlock()
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// retryingClient returns a client retrying twice without waiting.
+func retryingClient(opts ...Option) *BackoffClient {
+	return NewBackoffClient(append([]Option{
+		WithClock(&autoClock{now: time.Unix(0, 0)}),
+		WithBackoffStrategy(ConstantBackoff(time.Second)),
+		WithMaxRetry(2),
+	}, opts...)...)
+}
+
+func TestAttemptInfo(t *testing.T) {
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Clone())
+		if len(headers) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	var errorAttempts, retryAttempts, responseAttempts []int
+	transport := &recordingTransport{}
+	c := retryingClient(
+		WithClient(&http.Client{Transport: transport}),
+		WithAttemptHeaders(DefaultAttemptHeader, DefaultRequestIDHeader),
+		WithErrorLogHook(func(r *http.Request, err error, attempt int, duration time.Duration) {
+			errorAttempts = append(errorAttempts, attempt)
+		}),
+		WithResponseLogHook(func(r *http.Request, w *http.Response, attempt int, duration time.Duration) {
+			responseAttempts = append(responseAttempts, attempt)
+		}),
+		WithRequestLogHook(func(r *http.Request, err error, attempt int, next time.Duration) {
+			retryAttempts = append(retryAttempts, attempt)
+		}),
+	)
+
+	r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	if _, err := c.Execute(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(transport.attempts) != 3 || len(headers) != 3 {
+		t.Fatalf("got %d attempts and %d requests, want 3", len(transport.attempts), len(headers))
+	}
+
+	requestID := transport.attempts[0].RequestID
+	if !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(requestID) {
+		t.Errorf("got request ID %q, want 32 hex digits", requestID)
+	}
+
+	for i, info := range transport.attempts {
+		attempt := i + 1
+		if info.Attempt != attempt || info.Retry() != (attempt > 1) {
+			t.Errorf("attempt %d: got attempt %d, retry %v", attempt, info.Attempt, info.Retry())
+		}
+		if info.RequestID != requestID {
+			t.Errorf("attempt %d: got request ID %q, want %q", attempt, info.RequestID, requestID)
+		}
+
+		wantDelay := time.Second
+		if attempt == 1 {
+			wantDelay = 0
+		}
+		if info.Delay != wantDelay || (info.Reason == nil) != (attempt == 1) {
+			t.Errorf("attempt %d: got delay %v and reason %v", attempt, info.Delay, info.Reason)
+		}
+
+		if got, want := headers[i].Get(DefaultAttemptHeader), strconv.Itoa(attempt); got != want {
+			t.Errorf("attempt %d: got attempt header %q, want %q", attempt, got, want)
+		}
+		if got := headers[i].Get(DefaultRequestIDHeader); got != requestID {
+			t.Errorf("attempt %d: got request ID header %q, want %q", attempt, got, requestID)
+		}
+	}
+
+	// The hooks see the same attempt numbers.
+	if !slices.Equal(errorAttempts, []int{1, 2}) {
+		t.Errorf("got error hook attempts %v, want [1 2]", errorAttempts)
+	}
+	if !slices.Equal(retryAttempts, []int{1, 2}) {
+		t.Errorf("got request hook attempts %v, want [1 2]", retryAttempts)
+	}
+	if !slices.Equal(responseAttempts, []int{3}) {
+		t.Errorf("got response hook attempts %v, want [3]", responseAttempts)
+	}
+}
+
+func TestAttemptKeepsRequestID(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("X-Correlation-Id"))
+		if len(got) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	c := retryingClient(WithAttemptHeaders("", "X-Correlation-Id"))
+
+	r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	r.Header.Set("X-Correlation-Id", "abc")
+	if _, err := c.Execute(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 || got[0] != "abc" || got[1] != "abc" {
+		t.Errorf("got request IDs %q, want abc for both attempts", got)
+	}
+}
+
+func TestAttemptHeadersAreOptional(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(DefaultAttemptHeader) != "" || r.Header.Get(DefaultRequestIDHeader) != "" {
+			t.Errorf("got attempt headers %v without WithAttemptHeaders", r.Header)
+		}
+	}))
+	defer server.Close()
+
+	r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	if _, err := NewBackoffClient().Execute(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := AttemptFromContext(r.Context()); ok {
+		t.Error("got attempt info outside of an attempt")
+	}
+}
@@ -0,0 +1,179 @@
+package backoff
+
+import (
+	"io"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// AuthProvider adds credentials to outgoing requests.
+type AuthProvider interface {
+	// Authorize sets the credentials on r. It is called for every attempt
+	// on a clone of the original request.
+	Authorize(r *http.Request) error
+}
+
+// AuthProviderFunc adapts an ordinary function to AuthProvider.
+type AuthProviderFunc func(r *http.Request) error
+
+func (f AuthProviderFunc) Authorize(r *http.Request) error {
+	return f(r)
+}
+
+// tokenInvalidator is implemented by providers whose credentials can be
+// refreshed after the server rejected them with 401 Unauthorized.
+type tokenInvalidator interface {
+	invalidate(r *http.Request)
+}
+
+// BearerTokenAuth returns an AuthProvider that sends a static bearer token.
+func BearerTokenAuth(token string) AuthProvider {
+	return AuthProviderFunc(func(r *http.Request) error {
+		r.Header.Set(AuthorizationHeader, "Bearer "+token)
+		return nil
+	})
+}
+
+// BasicAuth returns an AuthProvider that sends HTTP basic credentials.
+func BasicAuth(username, password string) AuthProvider {
+	return AuthProviderFunc(func(r *http.Request) error {
+		r.SetBasicAuth(username, password)
+		return nil
+	})
+}
+
+// APIKeyHeaderAuth returns an AuthProvider that sends an API key in the given header.
+func APIKeyHeaderAuth(header, key string) AuthProvider {
+	return AuthProviderFunc(func(r *http.Request) error {
+		r.Header.Set(header, key)
+		return nil
+	})
+}
+
+// APIKeyQueryAuth returns an AuthProvider that sends an API key as the given query parameter.
+func APIKeyQueryAuth(param, key string) AuthProvider {
+	return AuthProviderFunc(func(r *http.Request) error {
+		u := *r.URL
+		query := u.Query()
+		query.Set(param, key)
+		u.RawQuery = query.Encode()
+		r.URL = &u
+		return nil
+	})
+}
+
+// TokenSourceAuth returns an AuthProvider that sends tokens from source.
+// When source is one of the token sources of this package, a request
+// rejected with 401 Unauthorized is retried once with a fresh token.
+func TokenSourceAuth(source oauth2.TokenSource) AuthProvider {
+	return &tokenAuth{source: source}
+}
+
+type tokenAuth struct {
+	source oauth2.TokenSource
+}
+
+func (a *tokenAuth) Authorize(r *http.Request) error {
+	token, err := a.source.Token()
+	if err != nil {
+		return err
+	}
+
+	token.SetAuthHeader(r)
+	return nil
+}
+
+func (a *tokenAuth) invalidate(r *http.Request) {
+	if source, ok := a.source.(tokenInvalidator); ok {
+		source.invalidate(r)
+	}
+}
+
+// authTransport is an http.RoundTripper that authorizes every request with
+// provider. When the server answers 401 Unauthorized and the provider can
+// refresh its credentials, the request is retried once, so that tokens
+// revoked server-side don't stick until they expire.
+type authTransport struct {
+	provider AuthProvider
+	base     http.RoundTripper
+}
+
+func newAuthTransport(provider AuthProvider, base http.RoundTripper) *authTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &authTransport{
+		provider: provider,
+		base:     base,
+	}
+}
+
+// RoundTrip authorizes the request and retries it once on 401 Unauthorized.
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, err := t.authorize(req)
+	if err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !canReplayBody(req) {
+		return resp, err
+	}
+
+	invalidator, ok := t.provider.(tokenInvalidator)
+	if !ok {
+		return resp, nil
+	}
+
+	invalidator.invalidate(r)
+
+	retry, err := t.authorize(req)
+	if err != nil || retry.Header.Get(AuthorizationHeader) == r.Header.Get(AuthorizationHeader) {
+		// Nothing better to offer, hand back the original 401.
+		return resp, nil
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+
+	drainBody(resp.Body)
+
+	return t.base.RoundTrip(retry)
+}
+
+// authorize returns a clone of req carrying the provider's credentials, per
+// the RoundTripper contract that forbids modifying the original request.
+func (t *authTransport) authorize(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if err := t.provider.Authorize(r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// canReplayBody reports whether r can be sent again.
+func canReplayBody(r *http.Request) bool {
+	return r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+}
+
+func closeRequestBody(r *http.Request) {
+	if r.Body != nil {
+		r.Body.Close()
+	}
+}
+
+// drainBody reads the remainder of body so the connection can be reused.
+func drainBody(body io.ReadCloser) {
+	const maxDrain = 4 << 10
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, maxDrain))
+	body.Close()
+}
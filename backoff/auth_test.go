@@ -0,0 +1,203 @@
+package backoff
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestAuthProviders(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider AuthProvider
+		header   string
+		want     string
+		query    string
+	}{
+		{name: "bearer", provider: BearerTokenAuth("token"), header: AuthorizationHeader, want: "Bearer token", query: "q=x"},
+		{name: "basic", provider: BasicAuth("user", "pass"), header: AuthorizationHeader, want: "Basic dXNlcjpwYXNz", query: "q=x"},
+		{name: "api key header", provider: APIKeyHeaderAuth("X-Api-Key", "key"), header: "X-Api-Key", want: "key", query: "q=x"},
+		{name: "api key query", provider: APIKeyQueryAuth("api_key", "a&b"), query: "api_key=a%26b&q=x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.header != "" && r.Header.Get(tt.header) != tt.want {
+					t.Errorf("got %s %q, want %q", tt.header, r.Header.Get(tt.header), tt.want)
+				}
+				if r.URL.RawQuery != tt.query {
+					t.Errorf("got query %q, want %q", r.URL.RawQuery, tt.query)
+				}
+			}))
+			defer server.Close()
+
+			r, _ := http.NewRequest(http.MethodGet, server.URL+"?q=x", nil)
+			if _, err := NewBackoffClient(WithAuth(tt.provider)).Execute(r); err != nil {
+				t.Fatal(err)
+			}
+
+			// The credentials are set on a copy of the caller's request.
+			if r.Header.Get(AuthorizationHeader) != "" || r.URL.RawQuery != "q=x" {
+				t.Errorf("got request header %v and query %q, want them unchanged", r.Header, r.URL.RawQuery)
+			}
+		})
+	}
+}
+
+func TestAuthProviderError(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	errNoCredentials := errors.New("no credentials")
+	c := NewBackoffClient(WithAuth(AuthProviderFunc(func(r *http.Request) error {
+		return errNoCredentials
+	})))
+
+	r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	if _, err := c.Execute(r); !errors.Is(err, errNoCredentials) {
+		t.Errorf("got error %v, want %v", err, errNoCredentials)
+	}
+	if requests != 0 {
+		t.Errorf("got %d requests, want none", requests)
+	}
+}
+
+// tokenServer answers 200 to the bearer token it accepts, 401 to others.
+func tokenServer(t *testing.T, accepted *atomic.Value) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get(AuthorizationHeader) != "Bearer "+accepted.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestTokenSourceAuthRefreshesFileToken(t *testing.T) {
+	var accepted atomic.Value
+	accepted.Store("token-1")
+	server, requests := tokenServer(t, &accepted)
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("token-1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewBackoffClient(WithAuth(TokenSourceAuth(NewFileTokenSource(path))))
+	get := func() int {
+		r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := c.Execute(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if status := get(); status != http.StatusOK {
+		t.Fatalf("got status %d, want 200", status)
+	}
+
+	// token-1 is revoked and replaced on disk by token-2, of the same size
+	// and modification time, so only the 401 makes the file read again.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted.Store("token-2")
+	if err := os.WriteFile(path, []byte("token-2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Time{}, info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	requests.Store(0)
+	if status := get(); status != http.StatusOK {
+		t.Errorf("got status %d after the token was revoked, want 200", status)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("got %d requests, want 2", got)
+	}
+
+	// The request is not sent again when the file holds the rejected token.
+	accepted.Store("token-3")
+	requests.Store(0)
+	if status := get(); status != http.StatusUnauthorized {
+		t.Errorf("got status %d, want 401", status)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("got %d requests, want 1", got)
+	}
+}
+
+func TestAuthWithoutRefresh(t *testing.T) {
+	var accepted atomic.Value
+	accepted.Store("other")
+	server, requests := tokenServer(t, &accepted)
+
+	// Neither static tokens nor foreign token sources can be refreshed.
+	for name, provider := range map[string]AuthProvider{
+		"static":       BearerTokenAuth("token"),
+		"token source": TokenSourceAuth(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})),
+	} {
+		requests.Store(0)
+
+		r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := NewBackoffClient(WithAuth(provider)).Execute(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized || requests.Load() != 1 {
+			t.Errorf("%s: got status %d after %d requests, want 401 after 1", name, resp.StatusCode, requests.Load())
+		}
+	}
+}
+
+// headerTransport sets a header on the requests it sends.
+type headerTransport struct {
+	key, value string
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(t.key, t.value)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestAuthWithClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Via") != "client" || r.Header.Get(AuthorizationHeader) != "Bearer token" {
+			t.Errorf("got header %v, want it set by the client and the provider", r.Header)
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: headerTransport{key: "X-Via", value: "client"}}
+	c := NewBackoffClient(WithClient(client), WithAuth(BearerTokenAuth("token")))
+
+	r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	if _, err := c.Execute(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client given is not modified.
+	if _, ok := client.Transport.(headerTransport); !ok {
+		t.Errorf("got transport %T, want the client's own", client.Transport)
+	}
+}
@@ -2,11 +2,12 @@ package backoff
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -34,6 +35,46 @@ type Response struct {
 	StatusCode int         `json:"status_code,omitempty"`
 	Header     http.Header `json:"header,omitempty"`
 	Body       []byte      `json:"body,omitempty"`
+
+	// codecs decode the body, accept is the Accept header sent.
+	codecs *CodecRegistry
+	accept string
+}
+
+// Decode decodes the body into v with the codec registered for the response
+// Content-Type. Responses without one are decoded with the codec for the
+// Accept header sent, falling back to JSON.
+func (r *Response) Decode(v any) error {
+	codecs := r.codecs
+	if codecs == nil {
+		codecs = DefaultCodecs
+	}
+
+	contentType := r.Header.Get(ContentTypeHeader)
+	if contentType != "" {
+		codec, ok := codecs.Lookup(contentType)
+		if !ok {
+			return fmt.Errorf("http-client: no codec registered for %q", contentType)
+		}
+		return codec.Unmarshal(r.Body, v)
+	}
+
+	codec, ok := codecs.lookupAccept(r.accept)
+	if !ok {
+		codec, ok = codecs.Lookup(ContentTypeJSON)
+	}
+	if !ok {
+		return errors.New("http-client: no codec registered for responses without Content-Type")
+	}
+
+	return codec.Unmarshal(r.Body, v)
+}
+
+// Decode decodes the response body into a T, see Response.Decode.
+func Decode[T any](r *Response) (T, error) {
+	var result T
+	err := r.Decode(&result)
+	return result, err
 }
 
 // RetryableError is an error that can be retried.
@@ -63,20 +104,42 @@ func (e *RetryableError) Is(target error) bool {
 	return ok
 }
 
+// StatusError is returned when the response status code is not one of the
+// expected ones, see WithExpectedStatus.
+type StatusError struct {
+	Response *Response `json:"response,omitempty"`
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("http-client: unexpected status code %s", e.Response.Status)
+}
+
 type BackoffClient struct {
 	*http.Client
-	cfg             config
-	backOffStrategy backoff.BackOff
+	cfg    config
+	flight flightGroup
+
+	// revalidations are the cache keys being revalidated in the background.
+	revalidations keySet
 }
 
+// NewBackoffClient returns a client configured by opts. Requests are sent
+// with the client set by WithClient, NewDefaultClient by default, and are
+// authorized by the provider set by WithAuth, if any.
 func NewBackoffClient(opts ...Option) *BackoffClient {
 	cfg := config{
 		service:         "http-client",
+		headers:         make(map[string]string),
 		maxRetry:        DefaultMaxRetry,
 		initialInterval: DefaultInitialInterval,
 		maxInterval:     DefaultMaxInterval,
 		multiplier:      DefaultMultiplier,
 		client:          NewDefaultClient(),
+		codecs:          DefaultCodecs,
+		clock:           SystemClock,
+		errorPolicy:     ErrorRetryPolicy,
+		responsePolicy:  ResponseRetryPolicy,
+		bodyPolicyLimit: DefaultBodyRetryPolicyLimit,
 		RequestLogHook:  func(r *http.Request, err error, n int, next time.Duration) {},
 		ResponseLogHook: func(r *http.Request, w *http.Response, n int, d time.Duration) {},
 		ErrorLogHook:    func(r *http.Request, err error, n int, d time.Duration) {},
@@ -86,159 +149,187 @@ func NewBackoffClient(opts ...Option) *BackoffClient {
 		opt.apply(&cfg)
 	}
 
-	var backOffStrategy backoff.BackOff = backoff.NewExponentialBackOff()
-	if cfg.maxRetry > 0 {
-		backOffStrategy = backoff.WithMaxRetries(backoff.NewExponentialBackOff(), cfg.maxRetry)
+	if cfg.userAgent == "" {
+		cfg.userAgent = cfg.service
+	}
+
+	client := cfg.client
+	if cfg.auth != nil {
+		authorized := *client
+		authorized.Transport = newAuthTransport(cfg.auth, client.Transport)
+		client = &authorized
 	}
 
 	return &BackoffClient{
-		cfg:             cfg,
-		backOffStrategy: backOffStrategy,
-		Client:          http.DefaultClient,
+		cfg:    cfg,
+		Client: client,
 	}
 }
 
+// R returns a RequestBuilder bound to the client, to be sent with Do.
+func (c *BackoffClient) R() *RequestBuilder {
+	rb := c.newRequestBuilder().Codecs(c.cfg.codecs)
+	rb.client = c
+	return rb
+}
+
 // Get performs an HTTP GET request.
 func (c *BackoffClient) Get(ctx context.Context, url string, headers map[string]string) (*Response, error) {
-	req, err := NewRequestBuilder().
-		Method(http.MethodGet).
-		URL(url).
-		Headers(headers).
-		Build(ctx)
-	if err != nil {
-		return nil, err
-	}
+	return c.DoRequest(ctx, http.MethodGet, url, nil, headers)
+}
 
-	return c.Execute(req)
+// Head performs an HTTP HEAD request. The response has no body.
+func (c *BackoffClient) Head(ctx context.Context, url string, headers map[string]string) (*Response, error) {
+	return c.DoRequest(ctx, http.MethodHead, url, nil, headers)
 }
 
-func (c *BackoffClient) Post(ctx context.Context, url string, body io.Reader, headers map[string]string) (*Response, error) {
-	req, err := NewRequestBuilder().
-		Method(http.MethodPost).
-		URL(url).
-		Body(body).
-		Headers(headers).
-		Build(ctx)
-	if err != nil {
-		return nil, err
-	}
+// Options performs an HTTP OPTIONS request.
+func (c *BackoffClient) Options(ctx context.Context, url string, headers map[string]string) (*Response, error) {
+	return c.DoRequest(ctx, http.MethodOptions, url, nil, headers)
+}
 
-	return c.Execute(req)
+// Post performs an HTTP POST request.
+func (c *BackoffClient) Post(ctx context.Context, url string, body io.Reader, headers map[string]string) (*Response, error) {
+	return c.DoRequest(ctx, http.MethodPost, url, body, headers)
 }
 
-// Post performs an HTTP POST request with a JSON body.
+// PostJSON performs an HTTP POST request with a JSON body.
 func (c *BackoffClient) PostJSON(ctx context.Context, url string, body any, headers map[string]string) (*Response, error) {
-	req, err := NewRequestBuilder().
-		Method(http.MethodPost).
-		URL(url).
-		BodyJSON(body).
-		Headers(headers).
-		Build(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	return c.Execute(req)
+	return c.DoJSON(ctx, http.MethodPost, url, body, headers)
 }
 
 // PostForm performs an HTTP POST request with form data.
 func (c *BackoffClient) PostForm(ctx context.Context, url string, form map[string]string, headers map[string]string) (*Response, error) {
-	req, err := NewRequestBuilder().
-		Method(http.MethodPost).
-		URL(url).
-		PostForm(form).
-		Headers(headers).
-		Build(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	return c.Execute(req)
+	return c.DoForm(ctx, http.MethodPost, url, form, headers)
 }
 
 // Put performs an HTTP PUT request.
 func (c *BackoffClient) Put(ctx context.Context, url string, body io.Reader, headers map[string]string) (*Response, error) {
-	req, err := NewRequestBuilder().
-		Method(http.MethodPut).
-		URL(url).
-		Body(body).
-		Headers(headers).
-		Build(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	return c.Execute(req)
+	return c.DoRequest(ctx, http.MethodPut, url, body, headers)
 }
 
 // PutJSON performs an HTTP PUT request with a JSON body.
 func (c *BackoffClient) PutJSON(ctx context.Context, url string, body any, headers map[string]string) (*Response, error) {
-	req, err := NewRequestBuilder().
-		Method(http.MethodPut).
-		URL(url).
-		BodyJSON(body).
-		Headers(headers).
-		Build(ctx)
-	if err != nil {
-		return nil, err
-	}
+	return c.DoJSON(ctx, http.MethodPut, url, body, headers)
+}
 
-	return c.Execute(req)
+// PutForm performs an HTTP PUT request with form data.
+func (c *BackoffClient) PutForm(ctx context.Context, url string, form map[string]string, headers map[string]string) (*Response, error) {
+	return c.DoForm(ctx, http.MethodPut, url, form, headers)
 }
 
 // Patch performs an HTTP PATCH request.
 func (c *BackoffClient) Patch(ctx context.Context, url string, body io.Reader, headers map[string]string) (*Response, error) {
-	req, err := NewRequestBuilder().
-		Method(http.MethodPatch).
+	return c.DoRequest(ctx, http.MethodPatch, url, body, headers)
+}
+
+// PatchJSON performs an HTTP PATCH request with a JSON body.
+func (c *BackoffClient) PatchJSON(ctx context.Context, url string, body any, headers map[string]string) (*Response, error) {
+	return c.DoJSON(ctx, http.MethodPatch, url, body, headers)
+}
+
+// PatchForm performs an HTTP PATCH request with form data.
+func (c *BackoffClient) PatchForm(ctx context.Context, url string, form map[string]string, headers map[string]string) (*Response, error) {
+	return c.DoForm(ctx, http.MethodPatch, url, form, headers)
+}
+
+// Delete performs an HTTP DELETE request.
+func (c *BackoffClient) Delete(ctx context.Context, url string, headers map[string]string) (*Response, error) {
+	return c.DoRequest(ctx, http.MethodDelete, url, nil, headers)
+}
+
+// DeleteWithBody performs an HTTP DELETE request with a body.
+func (c *BackoffClient) DeleteWithBody(ctx context.Context, url string, body io.Reader, headers map[string]string) (*Response, error) {
+	return c.DoRequest(ctx, http.MethodDelete, url, body, headers)
+}
+
+// DeleteJSON performs an HTTP DELETE request with a JSON body.
+func (c *BackoffClient) DeleteJSON(ctx context.Context, url string, body any, headers map[string]string) (*Response, error) {
+	return c.DoJSON(ctx, http.MethodDelete, url, body, headers)
+}
+
+// DoRequest performs an HTTP request with any method. The body may be nil.
+// Do is the one of the embedded http.Client, sending a single attempt.
+func (c *BackoffClient) DoRequest(ctx context.Context, method, url string, body io.Reader, headers map[string]string) (*Response, error) {
+	return c.R().
+		Method(method).
 		URL(url).
 		Body(body).
 		Headers(headers).
-		Build(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	return c.Execute(req)
+		Do(ctx)
 }
 
-// PatchJSON performs an HTTP PATCH request.
-func (c *BackoffClient) PatchJSON(ctx context.Context, url string, body any, headers map[string]string) (*Response, error) {
-	req, err := NewRequestBuilder().
-		Method(http.MethodPatch).
+// DoJSON performs an HTTP request with any method and a JSON body.
+func (c *BackoffClient) DoJSON(ctx context.Context, method, url string, body any, headers map[string]string) (*Response, error) {
+	return c.R().
+		Method(method).
 		URL(url).
 		BodyJSON(body).
 		Headers(headers).
-		Build(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	return c.Execute(req)
+		Do(ctx)
 }
 
-// Delete performs an HTTP DELETE request.
-func (c *BackoffClient) Delete(ctx context.Context, url string, headers map[string]string) (*Response, error) {
-	req, err := NewRequestBuilder().
-		Method(http.MethodDelete).
+// DoForm performs an HTTP request with any method and form data.
+func (c *BackoffClient) DoForm(ctx context.Context, method, url string, form map[string]string, headers map[string]string) (*Response, error) {
+	return c.R().
+		Method(method).
 		URL(url).
+		PostForm(form).
 		Headers(headers).
-		Build(ctx)
-	if err != nil {
-		return nil, err
+		Do(ctx)
+}
+
+// newRequestBuilder returns a RequestBuilder resolving relative URLs against the base URL.
+func (c *BackoffClient) newRequestBuilder() *RequestBuilder {
+	return NewRequestBuilder().BaseURL(c.cfg.baseURL)
+}
+
+// Execute performs the HTTP request and handles response. The options
+// override the client configuration for this request only.
+func (c *BackoffClient) Execute(r *http.Request, opts ...Option) (*Response, error) {
+	cfg := c.cfg.with(opts...)
+
+	// Route options override the client's, and are overridden by the request's.
+	if rule := cfg.matchRoute(r); rule != nil {
+		cfg = c.cfg.with(append(slices.Clip(rule.opts), opts...)...)
+		cfg.route = rule.route.Name
 	}
 
-	return c.Execute(req)
+	// Per-request options may change the outcome, e.g. the expected status,
+	// so such requests never share the outcome of another caller.
+	if cfg.coalesce && len(opts) == 0 && (r.Method == http.MethodGet || r.Method == http.MethodHead) && (r.Body == nil || r.Body == http.NoBody) {
+		return c.flight.do(r.Context(), coalesceKey(r, cfg.coalesceHeaders), func(ctx context.Context) (*Response, error) {
+			return c.executeRequest(r.Clone(ctx), &cfg)
+		})
+	}
+
+	return c.executeRequest(r, &cfg)
 }
 
-// Execute performs the HTTP request and handles response.
-func (c *BackoffClient) Execute(r *http.Request) (*Response, error) {
+// executeRequest performs the HTTP request, through the cache if there is one.
+func (c *BackoffClient) executeRequest(r *http.Request, cfg *config) (*Response, error) {
+	if cfg.cache != nil && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		return c.executeCached(r, cfg)
+	}
+
+	return c.executeWithRetry(r, cfg)
+}
+
+// executeWithRetry performs the HTTP request, retrying it according to cfg.
+func (c *BackoffClient) executeWithRetry(r *http.Request, cfg *config) (*Response, error) {
+	info := AttemptInfo{RequestID: r.Header.Get(cfg.requestIDHeader), Route: cfg.route}
+	if info.RequestID == "" {
+		info.RequestID = newRequestID()
+	}
+
 	attempt := 0
 	f := func() (*Response, error) {
 		attempt++
-		startTime := time.Now()
-		resp, err := c.execute(r)
+		info.Attempt = attempt
+		startTime := cfg.clock.Now()
+		resp, err := c.execute(r, info, cfg)
 		if err != nil {
-			c.cfg.ErrorLogHook(r, err, attempt, time.Since(startTime))
+			cfg.ErrorLogHook(r, err, attempt, cfg.clock.Now().Sub(startTime))
 
 			if errors.Is(err, &RetryableError{}) {
 				return nil, err
@@ -247,44 +338,108 @@ func (c *BackoffClient) Execute(r *http.Request) (*Response, error) {
 			return nil, backoff.Permanent(err)
 		}
 
-		c.cfg.ResponseLogHook(r, resp, attempt, time.Since(startTime))
+		return resp, nil
+	}
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			// c.cfg.ErrorHook(r, err, attempt, time.Since(startTime))
-			return nil, err
-		}
+	notify := func(err error, next time.Duration) {
+		info.Delay, info.Reason = next, err
+		cfg.RequestLogHook(r, err, attempt, next)
+	}
 
-		defer resp.Body.Close()
+	return backoff.RetryNotifyWithTimerAndData(f, newBackOff(r.Context(), cfg), notify, newTimer(cfg.clock))
+}
 
-		return &Response{
-			Status:     resp.Status,
-			StatusCode: resp.StatusCode,
-			Header:     resp.Header,
-			Body:       body,
-		}, nil
+// newBackOff returns the backoff strategy for a single Execute call.
+func newBackOff(ctx context.Context, cfg *config) backoff.BackOff {
+	var b backoff.BackOff
+	randomized, isRandomized := cfg.strategy.(randomizedStrategy)
+	switch {
+	case isRandomized && cfg.rand != nil:
+		b = newMaxElapsedBackOff(randomized.newBackOff(cfg.rand), cfg.clock)
+	case cfg.strategy != nil:
+		b = newMaxElapsedBackOff(cfg.strategy.NewBackOff(), cfg.clock)
+	case cfg.rand != nil:
+		b = newExponentialJitter(cfg, cfg.rand)
+	default:
+		b = backoff.NewExponentialBackOff(
+			backoff.WithInitialInterval(cfg.initialInterval),
+			backoff.WithMaxInterval(cfg.maxInterval),
+			backoff.WithMultiplier(cfg.multiplier),
+			backoff.WithMaxElapsedTime(DefaultMaxElapsedTime),
+			backoff.WithClockProvider(cfg.clock),
+		)
 	}
 
-	notify := func(err error, next time.Duration) {
-		c.cfg.RequestLogHook(r, err, attempt, next)
+	b = backoff.WithContext(b, ctx)
+	if cfg.maxRetry > 0 {
+		b = backoff.WithMaxRetries(b, cfg.maxRetry)
 	}
 
-	return backoff.RetryNotifyWithData(f, c.backOffStrategy, notify)
+	return b
 }
 
-// execute performs the HTTP request and handles response.
-func (c *BackoffClient) execute(r *http.Request) (*http.Response, error) {
+// execute performs the HTTP request and reads the response.
+func (c *BackoffClient) execute(r *http.Request, info AttemptInfo, cfg *config) (*Response, error) {
 	defer c.CloseIdleConnections()
 
-	if c.cfg.timeout != nil {
-		ctx, cancel := context.WithTimeout(r.Context(), *c.cfg.timeout)
-		r = r.WithContext(ctx)
+	startTime := cfg.clock.Now()
+	ctx := withAttempt(r.Context(), info)
+	if cfg.limiter != nil {
+		if err := cfg.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.timeout != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *cfg.timeout)
 		defer cancel()
 	}
 
-	resp, err := c.Do(r)
+	req, err := newAttemptRequest(ctx, r, info.Attempt)
 	if err != nil {
-		if ErrorRetryPolicy(err) {
+		return nil, err
+	}
+
+	// Default headers don't override the request's own
+	for key, value := range cfg.headers {
+		if _, ok := req.Header[key]; !ok {
+			req.Header.Set(key, value)
+		}
+	}
+
+	if req.Header.Get(UserAgentHeader) == "" {
+		req.Header.Set(UserAgentHeader, cfg.userAgent)
+	}
+
+	if cfg.attemptHeader != "" {
+		req.Header.Set(cfg.attemptHeader, strconv.Itoa(info.Attempt))
+	}
+
+	if cfg.requestIDHeader != "" && req.Header.Get(cfg.requestIDHeader) == "" {
+		req.Header.Set(cfg.requestIDHeader, info.RequestID)
+	}
+
+	if cfg.decompress && req.Header.Get(AcceptEncodingHeader) == "" {
+		req.Header.Set(AcceptEncodingHeader, acceptEncoding)
+	}
+
+	// Compress before signing, signatures cover the body as sent.
+	if cfg.requestEncoding != "" {
+		if err := compressRequest(req, cfg.requestEncoding, cfg.requestMinSize); err != nil {
+			return nil, fmt.Errorf("http-client: failed to compress request: %w", err)
+		}
+	}
+
+	if cfg.signer != nil {
+		if err := cfg.signer.Sign(req); err != nil {
+			return nil, fmt.Errorf("http-client: failed to sign request: %w", err)
+		}
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		if cfg.errorPolicy(err) {
 			return nil, &RetryableError{
 				Err: err,
 			}
@@ -293,14 +448,112 @@ func (c *BackoffClient) execute(r *http.Request) (*http.Response, error) {
 		return nil, err
 	}
 
-	if err := ResponseRetryPolicy(resp); err != nil {
+	defer resp.Body.Close()
+
+	if err := cfg.responsePolicy(resp); err != nil {
+		drainBody(resp.Body)
 		return nil, &RetryableError{
 			Response: resp,
 			Err:      err,
 		}
 	}
 
-	return resp, nil
+	cfg.ResponseLogHook(r, resp, info.Attempt, cfg.clock.Now().Sub(startTime))
+
+	// The body is read before the attempt's timeout is cancelled. HEAD
+	// responses have none, whatever their Content-Length says.
+	var body []byte
+	header := resp.Header
+	switch {
+	case req.Method == http.MethodHead:
+	case cfg.decompress && resp.Header.Get(ContentEncodingHeader) != "":
+		body, err = decodeBody(resp.Body, resp.Header.Get(ContentEncodingHeader), cfg.maxDecompressedSize)
+		if err != nil {
+			return nil, fmt.Errorf("http-client: failed to decompress response: %w", err)
+		}
+
+		header = resp.Header.Clone()
+		header.Del(ContentEncodingHeader)
+		header.Del("Content-Length")
+	default:
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, &RetryableError{
+				Response: resp,
+				Err:      err,
+			}
+		}
+	}
+
+	response := &Response{
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		Header:     header,
+		Body:       body,
+		codecs:     cfg.codecs,
+		accept:     req.Header.Get(AcceptHeader),
+	}
+
+	if err := checkStatus(response, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := checkBody(response, cfg); err != nil {
+		var retryable *RetryableError
+		if errors.As(err, &retryable) {
+			return nil, &RetryableError{
+				Response: resp,
+				Err:      retryable.Err,
+			}
+		}
+
+		return nil, &ValidationError{Response: response, Err: err}
+	}
+
+	if err := validateResponse(response, cfg); err != nil {
+		if cfg.retryInvalid && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil, &RetryableError{
+				Response: resp,
+				Err:      err,
+			}
+		}
+
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// checkStatus returns a StatusError unless the response has one of the
+// expected status codes, if any.
+func checkStatus(response *Response, cfg *config) error {
+	if len(cfg.expectedStatus) > 0 {
+		if _, ok := cfg.expectedStatus[response.StatusCode]; !ok {
+			return &StatusError{Response: response}
+		}
+	}
+	return nil
+}
+
+// newAttemptRequest returns a copy of r for the given attempt with the body
+// replayed from GetBody, so retries never send a drained body.
+func newAttemptRequest(ctx context.Context, r *http.Request, attempt int) (*http.Request, error) {
+	req := r.Clone(ctx)
+	if r.Body == nil || r.Body == http.NoBody || attempt == 1 {
+		return req, nil
+	}
+
+	if r.GetBody == nil {
+		return nil, errors.New("http-client: request body cannot be replayed")
+	}
+
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	req.Body = body
+	return req, nil
 }
 
 func ErrorRetryPolicy(err error) bool {
@@ -308,9 +561,11 @@ func ErrorRetryPolicy(err error) bool {
 		return true
 	}
 
-	// retry on oauth2 errors
-	if errors.Is(err, &oauth2.RetrieveError{}) {
-		return true
+	// retry on oauth2 token endpoint failures that may be transient,
+	// invalid credentials and the like (400, 401) are permanent.
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return retrieveErr.Response != nil && isRetryableStatus(retrieveErr.Response.StatusCode)
 	}
 
 	return false
@@ -322,19 +577,33 @@ func ResponseRetryPolicy(resp *http.Response) error {
 		return fmt.Errorf("status code retryable: %s", resp.Status)
 	}
 
-	// Check the response code. We retry on 500-range responses to allow
-	// the server time to recover, as 500's are typically not permanent
-	// errors and may relate to outages on the server side. This will catch
-	// invalid response codes as well, like [InternalServerError, BadGateway, ServiceUnavailable, GatewayTimeout).
-	if resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented {
+	if isRetryableStatus(resp.StatusCode) {
 		return fmt.Errorf("unexpected status code %s", resp.Status)
 	}
 
 	return nil
 }
 
+// isRetryableStatus reports whether the status code is worth retrying.
+func isRetryableStatus(code int) bool {
+	if _, ok := RetryableSet[code]; ok {
+		return true
+	}
+
+	// Check the response code. We retry on 500-range responses to allow
+	// the server time to recover, as 500's are typically not permanent
+	// errors and may relate to outages on the server side. This will catch
+	// invalid response codes as well, like [InternalServerError, BadGateway, ServiceUnavailable, GatewayTimeout).
+	return code >= 500 && code != http.StatusNotImplemented
+}
+
+// Unmarshal decodes a JSON body into a T with the JSON codec of DefaultCodecs.
 func Unmarshal[T any](response []byte) (T, error) {
 	var result T
-	err := json.Unmarshal(response, &result)
+	codec, ok := DefaultCodecs.Lookup(ContentTypeJSON)
+	if !ok {
+		return result, errors.New("http-client: no JSON codec registered")
+	}
+	err := codec.Unmarshal(response, &result)
 	return result, err
 }
@@ -0,0 +1,556 @@
+package backoff
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheEntry is a response stored in a CacheStore.
+type CacheEntry struct {
+	Response *Response `json:"response"`
+
+	// StoredAt is when the response was received or last revalidated.
+	StoredAt time.Time `json:"stored_at"`
+
+	// Vary holds the request header values selected by the response's Vary header.
+	Vary map[string]string `json:"vary,omitempty"`
+}
+
+// CacheStore stores cached responses by key. Implementations must be safe
+// for concurrent use. Stores are best-effort, failures are reported as misses.
+type CacheStore interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, entry *CacheEntry)
+	Delete(key string)
+}
+
+// MemoryCache is an in-memory CacheStore evicting the least recently used
+// entries once full.
+type MemoryCache struct {
+	maxEntries int
+
+	mu      sync.Mutex // guards the fields below
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+// NewMemoryCache returns a MemoryCache holding at most maxEntries responses,
+// zero means no limit.
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (m *MemoryCache) Get(key string) (*CacheEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	element, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	m.lru.MoveToFront(element)
+	return element.Value.(*memoryCacheItem).entry, true
+}
+
+func (m *MemoryCache) Set(key string, entry *CacheEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if element, ok := m.entries[key]; ok {
+		element.Value.(*memoryCacheItem).entry = entry
+		m.lru.MoveToFront(element)
+		return
+	}
+
+	m.entries[key] = m.lru.PushFront(&memoryCacheItem{key: key, entry: entry})
+	if m.maxEntries > 0 && m.lru.Len() > m.maxEntries {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryCacheItem).key)
+	}
+}
+
+func (m *MemoryCache) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if element, ok := m.entries[key]; ok {
+		m.lru.Remove(element)
+		delete(m.entries, key)
+	}
+}
+
+// DiskCache is a CacheStore keeping every response as a JSON file in a directory.
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache returns a DiskCache storing responses in dir, which is created if needed.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &DiskCache{dir: dir}, nil
+}
+
+func (d *DiskCache) Get(key string) (*CacheEntry, bool) {
+	data, err := os.ReadFile(d.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	entry := new(CacheEntry)
+	if err := json.Unmarshal(data, entry); err != nil || entry.Response == nil {
+		return nil, false
+	}
+
+	return entry, true
+}
+
+func (d *DiskCache) Set(key string, entry *CacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	// Write to a temporary file first so readers never see partial entries.
+	tmp, err := os.CreateTemp(d.dir, "tmp-*")
+	if err != nil {
+		return
+	}
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+func (d *DiskCache) Delete(key string) {
+	os.Remove(d.path(key))
+}
+
+func (d *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// cacheableStatus are the status codes cacheable by default (RFC 9110 15.1).
+var cacheableStatus = map[int]struct{}{
+	http.StatusOK:                   {},
+	http.StatusNonAuthoritativeInfo: {},
+	http.StatusNoContent:            {},
+	http.StatusMultipleChoices:      {},
+	http.StatusMovedPermanently:     {},
+	http.StatusPermanentRedirect:    {},
+	http.StatusNotFound:             {},
+	http.StatusMethodNotAllowed:     {},
+	http.StatusGone:                 {},
+	http.StatusRequestURITooLong:    {},
+	http.StatusNotImplemented:       {},
+}
+
+// executeCached serves GET and HEAD requests from cfg.cache when the stored
+// response is fresh, revalidates it with If-None-Match and If-Modified-Since
+// otherwise, and falls back to it on errors when stale-if-error allows.
+func (c *BackoffClient) executeCached(r *http.Request, cfg *config) (*Response, error) {
+	requestCC := parseCacheControl(r.Header.Values("Cache-Control"))
+	if _, ok := requestCC["no-store"]; ok {
+		return c.executeWithRetry(r, cfg)
+	}
+
+	key := cacheKey(r)
+	entry, ok := cfg.cache.Get(key)
+	if ok && !entry.matches(r) {
+		entry, ok = nil, false
+	}
+
+	if !ok {
+		return c.fetchAndStore(r, key, nil, cfg)
+	}
+
+	now := cfg.clock.Now()
+	responseCC := parseCacheControl(entry.Response.Header.Values("Cache-Control"))
+	age := entry.age(now)
+	staleness := age - entry.lifetime(responseCC)
+
+	_, noCache := responseCC["no-cache"]
+	_, mustRevalidate := responseCC["must-revalidate"]
+	if _, ok := requestCC["no-cache"]; ok {
+		noCache = true
+	}
+	if maxAge, ok := cacheDirectiveDuration(requestCC, "max-age"); ok && age > maxAge {
+		noCache = true
+	}
+
+	if !noCache && staleness < 0 {
+		return entry.serve(cfg, age)
+	}
+
+	if swr, ok := cacheDirectiveDuration(responseCC, "stale-while-revalidate"); ok && !noCache && !mustRevalidate && staleness < swr {
+		// Concurrent hits share a single background revalidation.
+		if c.revalidations.add(key) {
+			revalidation := r.Clone(context.WithoutCancel(r.Context()))
+			go func() {
+				defer c.revalidations.remove(key)
+				c.fetchAndStore(revalidation, key, entry, cfg) //nolint:errcheck // background revalidation
+			}()
+		}
+		return entry.serve(cfg, age)
+	}
+
+	resp, err := c.fetchAndStore(r, key, entry, cfg)
+	if mustRevalidate || !staleIfErrorApplies(resp, err) {
+		return resp, err
+	}
+
+	sie, ok := cacheDirectiveDuration(responseCC, "stale-if-error")
+	if requestSIE, requestOK := cacheDirectiveDuration(requestCC, "stale-if-error"); requestOK {
+		sie, ok = requestSIE, true
+	}
+	if ok && staleness < sie {
+		return entry.serve(cfg, age)
+	}
+
+	return nil, err
+}
+
+// fetchAndStore executes r, conditionally if there is a stored entry, and
+// stores the response if it is cacheable.
+func (c *BackoffClient) fetchAndStore(r *http.Request, key string, entry *CacheEntry, cfg *config) (*Response, error) {
+	req, fetchCfg := r, cfg
+	revalidating := entry != nil && (entry.Response.Header.Get("ETag") != "" || entry.Response.Header.Get("Last-Modified") != "")
+	if revalidating {
+		req = r.Clone(r.Context())
+		if etag := entry.Response.Header.Get("ETag"); etag != "" && req.Header.Get("If-None-Match") == "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if modified := entry.Response.Header.Get("Last-Modified"); modified != "" && req.Header.Get("If-Modified-Since") == "" {
+			req.Header.Set("If-Modified-Since", modified)
+		}
+
+		// 304 Not Modified is always expected when revalidating, the stored
+		// response it confirms is checked instead.
+		revalidateCfg := *cfg
+		if len(cfg.expectedStatus) > 0 {
+			revalidateCfg.expectedStatus = map[int]struct{}{http.StatusNotModified: {}}
+			for code := range cfg.expectedStatus {
+				revalidateCfg.expectedStatus[code] = struct{}{}
+			}
+		}
+		revalidateCfg.validators = make([]ResponseValidatorFunc, len(cfg.validators))
+		for i, validate := range cfg.validators {
+			revalidateCfg.validators[i] = func(resp *Response) error {
+				if resp.StatusCode == http.StatusNotModified {
+					return nil
+				}
+				return validate(resp)
+			}
+		}
+		fetchCfg = &revalidateCfg
+	}
+
+	resp, err := c.executeWithRetry(req, fetchCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	now := cfg.clock.Now()
+	if revalidating && resp.StatusCode == http.StatusNotModified {
+		updated := entry.revalidated(resp, now)
+		cfg.cache.Set(key, updated)
+		return updated.serve(cfg, 0)
+	}
+
+	if storable(r, resp) {
+		cfg.cache.Set(key, newCacheEntry(r, resp, now))
+	} else if r.Method == http.MethodGet && replacesRepresentation(resp) {
+		cfg.cache.Delete(key)
+	}
+
+	return resp, nil
+}
+
+// staleIfErrorApplies reports whether stale-if-error covers the outcome of
+// a fetch: a transport error or a 5xx response (RFC 5861 4). Cancelled
+// requests and responses failing decoding or validation are not covered.
+func staleIfErrorApplies(resp *Response, err error) bool {
+	if err == nil {
+		return resp.StatusCode >= 500
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Response.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var retryableErr *RetryableError
+	return errors.As(err, &retryableErr) && retryableErr.Response != nil && retryableErr.Response.StatusCode >= 500
+}
+
+// replacesRepresentation reports whether a response that is not stored
+// supersedes the stored one. 304 Not Modified answers to the caller's own
+// conditional requests and server errors leave it in place.
+func replacesRepresentation(resp *Response) bool {
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// credentialHeaders are part of the cache key, so that responses fetched
+// with some credentials are never served to callers with others.
+var credentialHeaders = []string{AuthorizationHeader, "Cookie"}
+
+// cacheKey identifies a stored response by method, URL and credentials.
+func cacheKey(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte(' ')
+	b.WriteString(r.URL.String())
+	for _, name := range credentialHeaders {
+		b.WriteByte('\n')
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// storable reports whether a private cache may store resp (RFC 9111 3).
+func storable(r *http.Request, resp *Response) bool {
+	responseCC := parseCacheControl(resp.Header.Values("Cache-Control"))
+	if _, ok := responseCC["no-store"]; ok {
+		return false
+	}
+
+	if strings.TrimSpace(resp.Header.Get("Vary")) == "*" {
+		return false
+	}
+
+	if _, ok := cacheableStatus[resp.StatusCode]; ok {
+		return true
+	}
+
+	// Other final responses need explicit freshness information.
+	_, hasMaxAge := responseCC["max-age"]
+	return resp.StatusCode < 300 && (hasMaxAge || resp.Header.Get("Expires") != "")
+}
+
+func newCacheEntry(r *http.Request, resp *Response, now time.Time) *CacheEntry {
+	entry := &CacheEntry{
+		Response: &Response{
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header.Clone(),
+			Body:       bytes.Clone(resp.Body),
+		},
+		StoredAt: now,
+	}
+
+	for _, field := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(field, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if entry.Vary == nil {
+				entry.Vary = make(map[string]string)
+			}
+			entry.Vary[name] = strings.Join(r.Header.Values(name), ",")
+		}
+	}
+
+	return entry
+}
+
+// matches reports whether r selects the same variant as the stored request.
+func (e *CacheEntry) matches(r *http.Request) bool {
+	for name, value := range e.Vary {
+		if strings.Join(r.Header.Values(name), ",") != value {
+			return false
+		}
+	}
+	return true
+}
+
+// age returns the current age of the stored response (RFC 9111 4.2.3).
+func (e *CacheEntry) age(now time.Time) time.Duration {
+	age := now.Sub(e.StoredAt)
+	if seconds, err := strconv.ParseInt(e.Response.Header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		age += time.Duration(seconds) * time.Second
+	}
+	return age
+}
+
+// lifetime returns the freshness lifetime of the stored response (RFC 9111 4.2.1).
+func (e *CacheEntry) lifetime(responseCC map[string]string) time.Duration {
+	if maxAge, ok := cacheDirectiveDuration(responseCC, "max-age"); ok {
+		return maxAge
+	}
+
+	date := e.StoredAt
+	if t, err := http.ParseTime(e.Response.Header.Get("Date")); err == nil {
+		date = t
+	}
+
+	if expires := e.Response.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return t.Sub(date)
+	}
+
+	// Heuristic freshness, a tenth of the time since the last modification.
+	if modified, err := http.ParseTime(e.Response.Header.Get("Last-Modified")); err == nil && date.After(modified) {
+		return date.Sub(modified) / 10
+	}
+
+	return 0
+}
+
+// revalidated returns the entry updated with the headers of a 304 response.
+func (e *CacheEntry) revalidated(resp *Response, now time.Time) *CacheEntry {
+	header := e.Response.Header.Clone()
+	for key, values := range resp.Header {
+		if key == "Content-Length" {
+			continue
+		}
+		header[key] = values
+	}
+	header.Del("Age")
+
+	return &CacheEntry{
+		Response: &Response{
+			Status:     e.Response.Status,
+			StatusCode: e.Response.StatusCode,
+			Header:     header,
+			Body:       e.Response.Body,
+		},
+		StoredAt: now,
+		Vary:     e.Vary,
+	}
+}
+
+// serve returns a copy of the stored response with its Age header set,
+// checked against the expected status codes and validators like a response
+// received from the server.
+func (e *CacheEntry) serve(cfg *config, age time.Duration) (*Response, error) {
+	header := e.Response.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+
+	response := &Response{
+		Status:     e.Response.Status,
+		StatusCode: e.Response.StatusCode,
+		Header:     header,
+		Body:       bytes.Clone(e.Response.Body),
+		codecs:     cfg.codecs,
+	}
+
+	if err := checkStatus(response, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateResponse(response, cfg); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// keySet is a set of keys safe for concurrent use.
+type keySet struct {
+	mu   sync.Mutex // guards keys
+	keys map[string]struct{}
+}
+
+// add adds key to the set and reports whether it was missing.
+func (s *keySet) add(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key]; ok {
+		return false
+	}
+
+	if s.keys == nil {
+		s.keys = make(map[string]struct{})
+	}
+	s.keys[key] = struct{}{}
+	return true
+}
+
+func (s *keySet) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, key)
+}
+
+// parseCacheControl parses Cache-Control header values into lower-cased
+// directives and their unquoted arguments.
+func parseCacheControl(values []string) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// cacheDirectiveDuration returns the delta-seconds argument of a directive.
+func cacheDirectiveDuration(directives map[string]string, name string) (time.Duration, bool) {
+	arg, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
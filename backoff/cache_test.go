@@ -0,0 +1,328 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cachedGet executes a GET request to url with c.
+func cachedGet(t *testing.T, c *BackoffClient, url string, opts ...Option) (*Response, error) {
+	t.Helper()
+
+	r, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Execute(r, opts...)
+}
+
+func TestCacheFreshHitChecksExpectedStatus(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	c := NewBackoffClient(WithCache(NewMemoryCache(0)), WithMaxRetry(1))
+
+	resp, err := cachedGet(t, c, server.URL)
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("got %v, %v, want 404", resp, err)
+	}
+
+	var statusErr *StatusError
+	if _, err := cachedGet(t, c, server.URL, WithExpectedStatus(http.StatusOK)); !errors.As(err, &statusErr) {
+		t.Errorf("got error %v, want StatusError", err)
+	}
+
+	if got := hits.Load(); got != 1 {
+		t.Errorf("got %d requests, want 1", got)
+	}
+}
+
+func TestCacheFreshHitRunsValidators(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	c := NewBackoffClient(WithCache(NewMemoryCache(0)), WithMaxRetry(1))
+
+	if _, err := cachedGet(t, c, server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	var validationErr *ValidationError
+	_, err := cachedGet(t, c, server.URL, WithResponseValidator(RequireContentType("application/json")))
+	if !errors.As(err, &validationErr) {
+		t.Errorf("got error %v, want ValidationError", err)
+	}
+}
+
+func TestCacheRevalidationChecksStoredResponse(t *testing.T) {
+	var conditional atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	c := NewBackoffClient(
+		WithCache(NewMemoryCache(0)),
+		WithMaxRetry(1),
+		WithExpectedStatus(http.StatusOK),
+		WithResponseValidator(RequireContentType("application/json")),
+	)
+
+	for i := 0; i < 2; i++ {
+		resp, err := cachedGet(t, c, server.URL)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if resp.StatusCode != http.StatusOK || string(resp.Body) != `{}` {
+			t.Errorf("request %d: got %d %q, want 200 {}", i, resp.StatusCode, resp.Body)
+		}
+	}
+
+	if got := conditional.Load(); got != 1 {
+		t.Errorf("got %d conditional requests, want 1", got)
+	}
+}
+
+func TestCacheStaleWhileRevalidateOnce(t *testing.T) {
+	var conditional atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") != "" {
+			conditional.Add(1)
+			<-release
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("stale"))
+	}))
+	defer server.Close()
+
+	c := NewBackoffClient(WithCache(NewMemoryCache(0)), WithMaxRetry(1))
+
+	if _, err := cachedGet(t, c, server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := cachedGet(t, c, server.URL)
+			if err != nil || string(resp.Body) != "stale" {
+				t.Errorf("got %v, %v, want stale response", resp, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for conditional.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	for {
+		c.revalidations.mu.Lock()
+		pending := len(c.revalidations.keys)
+		c.revalidations.mu.Unlock()
+		if pending == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := conditional.Load(); got != 1 {
+		t.Errorf("got %d background revalidations, want 1", got)
+	}
+}
+
+func TestCacheResponsesDoNotShareBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("cached"))
+	}))
+	defer server.Close()
+
+	c := NewBackoffClient(WithCache(NewMemoryCache(0)), WithMaxRetry(1))
+
+	for i := 0; i < 3; i++ {
+		resp, err := cachedGet(t, c, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Body) != "cached" {
+			t.Fatalf("request %d: got body %q, want %q", i, resp.Body, "cached")
+		}
+		copy(resp.Body, "XXXXXX")
+	}
+}
+
+func TestCacheKeySeparatesCredentials(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(r.Header.Get(AuthorizationHeader)))
+	}))
+	defer server.Close()
+
+	c := NewBackoffClient(WithCache(NewMemoryCache(0)), WithMaxRetry(1))
+
+	for _, authorization := range []string{"Bearer a", "Bearer b", "Bearer a", "Bearer b"} {
+		r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		r.Header.Set(AuthorizationHeader, authorization)
+
+		resp, err := c.Execute(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Body) != authorization {
+			t.Errorf("with %q: got response for %q", authorization, resp.Body)
+		}
+	}
+
+	if got := hits.Load(); got != 2 {
+		t.Errorf("got %d requests, want 2", got)
+	}
+}
+
+func TestCacheStaleIfError(t *testing.T) {
+	var fail atomic.Value // func(http.ResponseWriter) or nil
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f, _ := fail.Load().(func(http.ResponseWriter)); f != nil {
+			f(w)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=0, stale-if-error=60")
+		w.Write([]byte("stale"))
+	}))
+	defer server.Close()
+
+	rejectBad := WithResponseValidator(func(resp *Response) error {
+		if string(resp.Body) == "bad" {
+			return errors.New("bad body")
+		}
+		return nil
+	})
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		fail  func(http.ResponseWriter)
+		opts  []Option
+		stale bool
+	}{
+		{
+			name:  "server error",
+			fail:  func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+			stale: true,
+		},
+		{
+			name:  "transport error",
+			fail:  func(http.ResponseWriter) { panic(http.ErrAbortHandler) },
+			stale: true,
+		},
+		{
+			name: "client error",
+			fail: func(w http.ResponseWriter) { w.WriteHeader(http.StatusForbidden) },
+		},
+		{
+			name: "validation error",
+			fail: func(w http.ResponseWriter) { w.Write([]byte("bad")) },
+			opts: []Option{rejectBad},
+		},
+		{
+			name: "cancelled",
+			ctx:  cancelled,
+			fail: func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewBackoffClient(WithCache(NewMemoryCache(0)), WithMaxRetry(1))
+
+			fail.Store((func(http.ResponseWriter))(nil))
+			if _, err := cachedGet(t, c, server.URL); err != nil {
+				t.Fatal(err)
+			}
+			fail.Store(tt.fail)
+
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			r, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+
+			resp, err := c.Execute(r, tt.opts...)
+			served := err == nil && string(resp.Body) == "stale"
+			if served != tt.stale {
+				t.Errorf("got %v, %v, want stale response %v", resp, err, tt.stale)
+			}
+		})
+	}
+}
+
+func TestCacheKeepsEntryOnCallerConditionalRequest(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("cached"))
+	}))
+	defer server.Close()
+
+	c := NewBackoffClient(WithCache(NewMemoryCache(0)), WithMaxRetry(1))
+	if _, err := cachedGet(t, c, server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	r.Header.Set("If-None-Match", `"caller"`)
+	r.Header.Set("Cache-Control", "no-cache")
+	resp, err := c.Execute(r)
+	if err != nil || resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("got %v, %v, want 304", resp, err)
+	}
+
+	resp, err = cachedGet(t, c, server.URL)
+	if err != nil || string(resp.Body) != "cached" {
+		t.Errorf("got %v, %v, want the stored response", resp, err)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("got %d requests, want 2", got)
+	}
+}
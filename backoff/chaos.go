@@ -0,0 +1,208 @@
+package backoff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+var (
+	// ErrChaosInjected is returned by a ChaosTransport for error faults without an error.
+	ErrChaosInjected = errors.New("http-client: chaos: injected error")
+	// ErrChaosConnectionDropped is returned by a ChaosTransport for dropped connections.
+	ErrChaosConnectionDropped = errors.New("http-client: chaos: connection dropped")
+)
+
+// Fault describes a failure injected by a ChaosTransport. Latency delays the
+// request, the other fields replace or alter its outcome.
+type Fault struct {
+	// Probability of injecting the fault into a matching request, from 0 to 1.
+	Probability float64
+
+	// URLPattern restricts the fault to request URLs it matches, all if nil.
+	URLPattern *regexp.Regexp
+
+	// Latency is waited before sending the request.
+	Latency time.Duration
+
+	// Err is returned without sending the request.
+	Err error
+
+	// StatusCode is returned with an empty body without sending the request.
+	StatusCode int
+
+	// Drop sends the request but loses the response, as if the connection
+	// broke, and returns ErrChaosConnectionDropped.
+	Drop bool
+
+	// PartialBody cuts the response body after half of its Content-Length,
+	// or after PartialBodyUnknownLength bytes if the length is unknown. The
+	// read then fails with io.ErrUnexpectedEOF.
+	PartialBody bool
+}
+
+// PartialBodyUnknownLength is the number of bytes a PartialBody fault lets
+// through for bodies of unknown length, e.g. chunked responses.
+const PartialBodyUnknownLength = 512
+
+// LatencyFault delays requests by d with probability p.
+func LatencyFault(p float64, d time.Duration) Fault {
+	return Fault{Probability: p, Latency: d}
+}
+
+// ErrorFault fails requests with err, or ErrChaosInjected if nil, with probability p.
+func ErrorFault(p float64, err error) Fault {
+	if err == nil {
+		err = ErrChaosInjected
+	}
+	return Fault{Probability: p, Err: err}
+}
+
+// StatusFault answers requests with code with probability p.
+func StatusFault(p float64, code int) Fault {
+	return Fault{Probability: p, StatusCode: code}
+}
+
+// DropFault drops the response of requests with probability p.
+func DropFault(p float64) Fault {
+	return Fault{Probability: p, Drop: true}
+}
+
+// PartialBodyFault truncates response bodies with probability p.
+func PartialBodyFault(p float64) Fault {
+	return Fault{Probability: p, PartialBody: true}
+}
+
+// ForURL returns the fault restricted to request URLs matching pattern.
+func (f Fault) ForURL(pattern *regexp.Regexp) Fault {
+	f.URLPattern = pattern
+	return f
+}
+
+// ChaosTransport wraps a RoundTripper and injects faults into its requests,
+// e.g. to check retry policies against a healthy upstream:
+//
+//	transport := NewChaosTransport(NewPooledTransport(),
+//		LatencyFault(0.2, 500*time.Millisecond),
+//		StatusFault(0.1, http.StatusServiceUnavailable).ForURL(regexp.MustCompile(`/orders`)),
+//	)
+//
+// Every fault is drawn independently in order. Latencies add up, and the
+// first fault that replaces the outcome of the request wins.
+type ChaosTransport struct {
+	base   http.RoundTripper
+	faults []Fault
+	rand   *rand.Rand
+}
+
+// NewChaosTransport returns a ChaosTransport injecting faults into the
+// requests of base, http.DefaultTransport if nil.
+func NewChaosTransport(base http.RoundTripper, faults ...Fault) *ChaosTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &ChaosTransport{base: base, faults: faults, rand: globalRand}
+}
+
+// SetRandSource sets the random source deciding which faults are injected,
+// e.g. SetRandSource(rand.NewPCG(1, 2)) for reproducible runs.
+func (t *ChaosTransport) SetRandSource(src rand.Source) {
+	t.rand = rand.New(&lockedSource{src: src})
+}
+
+func (t *ChaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var latency time.Duration
+	var outcome *Fault
+	for i := range t.faults {
+		fault := &t.faults[i]
+		if fault.URLPattern != nil && !fault.URLPattern.MatchString(req.URL.String()) {
+			continue
+		}
+		if t.rand.Float64() >= fault.Probability {
+			continue
+		}
+
+		latency += fault.Latency
+		if outcome == nil && (fault.Err != nil || fault.StatusCode != 0 || fault.Drop || fault.PartialBody) {
+			outcome = fault
+		}
+	}
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			closeRequestBody(req)
+			return nil, req.Context().Err()
+		}
+	}
+
+	switch {
+	case outcome == nil:
+		return t.base.RoundTrip(req)
+	case outcome.Err != nil:
+		closeRequestBody(req)
+		return nil, outcome.Err
+	case outcome.StatusCode != 0:
+		closeRequestBody(req)
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", outcome.StatusCode, http.StatusText(outcome.StatusCode)),
+			StatusCode:    outcome.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        make(http.Header),
+			Body:          http.NoBody,
+			ContentLength: 0,
+			Request:       req,
+		}, nil
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if outcome.Drop {
+		drainBody(resp.Body)
+		return nil, ErrChaosConnectionDropped
+	}
+
+	remaining := int64(PartialBodyUnknownLength)
+	if resp.ContentLength >= 0 {
+		remaining = resp.ContentLength / 2
+	}
+
+	resp.Body = &partialBody{ReadCloser: resp.Body, remaining: remaining}
+	return resp, nil
+}
+
+// partialBody fails with io.ErrUnexpectedEOF after remaining bytes.
+type partialBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *partialBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
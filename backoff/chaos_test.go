@@ -0,0 +1,224 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubTransport answers every request with 200 and body, of unknown length
+// if chunked is set, and counts the requests.
+type stubTransport struct {
+	body    string
+	chunked bool
+	calls   atomic.Int32
+}
+
+func (t *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls.Add(1)
+
+	length := int64(len(t.body))
+	if t.chunked {
+		length = -1
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(strings.NewReader(t.body)),
+		ContentLength: length,
+		Request:       req,
+	}, nil
+}
+
+// newChaos returns a seeded ChaosTransport over base.
+func newChaos(base http.RoundTripper, faults ...Fault) *ChaosTransport {
+	transport := NewChaosTransport(base, faults...)
+	transport.SetRandSource(rand.NewPCG(1, 2))
+	return transport
+}
+
+func chaosGet(t *testing.T, transport http.RoundTripper, url string) (*http.Response, error) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transport.RoundTrip(req)
+}
+
+func TestChaosTransportFaults(t *testing.T) {
+	errCustom := errors.New("custom")
+
+	tests := []struct {
+		name   string
+		fault  Fault
+		err    error
+		status int
+		calls  int32
+	}{
+		{name: "error", fault: ErrorFault(1, errCustom), err: errCustom},
+		{name: "default error", fault: ErrorFault(1, nil), err: ErrChaosInjected},
+		{name: "status", fault: StatusFault(1, http.StatusServiceUnavailable), status: http.StatusServiceUnavailable},
+		{name: "drop", fault: DropFault(1), err: ErrChaosConnectionDropped, calls: 1},
+		{name: "latency only", fault: LatencyFault(1, time.Millisecond), status: http.StatusOK, calls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := &stubTransport{body: "ok"}
+			resp, err := chaosGet(t, newChaos(base, tt.fault), "https://example.com")
+
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+			if tt.status != 0 && (resp == nil || resp.StatusCode != tt.status) {
+				t.Errorf("got response %v, want status %d", resp, tt.status)
+			}
+			if got := base.calls.Load(); got != tt.calls {
+				t.Errorf("got %d requests upstream, want %d", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestChaosTransportPartialBody(t *testing.T) {
+	body := strings.Repeat("x", 2000)
+
+	tests := []struct {
+		name    string
+		chunked bool
+		want    int
+	}{
+		{name: "known length", want: len(body) / 2},
+		{name: "unknown length", chunked: true, want: PartialBodyUnknownLength},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := &stubTransport{body: body, chunked: tt.chunked}
+			resp, err := chaosGet(t, newChaos(base, PartialBodyFault(1)), "https://example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			data, err := io.ReadAll(resp.Body)
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("got error %v, want io.ErrUnexpectedEOF", err)
+			}
+			if len(data) != tt.want {
+				t.Errorf("got %d bytes, want %d", len(data), tt.want)
+			}
+		})
+	}
+
+	t.Run("short body of unknown length", func(t *testing.T) {
+		base := &stubTransport{body: "short", chunked: true}
+		resp, err := chaosGet(t, newChaos(base, PartialBodyFault(1)), "https://example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if _, err := io.ReadAll(resp.Body); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("got error %v, want io.ErrUnexpectedEOF", err)
+		}
+	})
+}
+
+func TestChaosTransportProbability(t *testing.T) {
+	const requests = 1000
+
+	injected := func(transport *ChaosTransport) []bool {
+		got := make([]bool, requests)
+		for i := range got {
+			_, err := chaosGet(t, transport, "https://example.com")
+			got[i] = err != nil
+		}
+		return got
+	}
+
+	count := func(results []bool) int {
+		n := 0
+		for _, ok := range results {
+			if ok {
+				n++
+			}
+		}
+		return n
+	}
+
+	if got := count(injected(newChaos(&stubTransport{}, ErrorFault(0, nil)))); got != 0 {
+		t.Errorf("probability 0: got %d faults, want none", got)
+	}
+	if got := count(injected(newChaos(&stubTransport{}, ErrorFault(1, nil)))); got != requests {
+		t.Errorf("probability 1: got %d faults, want %d", got, requests)
+	}
+
+	first := injected(newChaos(&stubTransport{}, ErrorFault(0.3, nil)))
+	second := injected(newChaos(&stubTransport{}, ErrorFault(0.3, nil)))
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("request %d: got different faults with the same seed", i)
+		}
+	}
+	if got := count(first); got < 250 || got > 350 {
+		t.Errorf("probability 0.3: got %d faults in %d requests", got, requests)
+	}
+}
+
+func TestChaosTransportForURL(t *testing.T) {
+	base := &stubTransport{body: "ok"}
+	transport := newChaos(base, StatusFault(1, http.StatusServiceUnavailable).ForURL(regexp.MustCompile(`/orders`)))
+
+	for url, want := range map[string]int{
+		"https://example.com/orders/1": http.StatusServiceUnavailable,
+		"https://example.com/users/1":  http.StatusOK,
+	} {
+		resp, err := chaosGet(t, transport, url)
+		if err != nil || resp.StatusCode != want {
+			t.Errorf("%s: got %v, %v, want %d", url, resp, err, want)
+		}
+	}
+
+	if got := base.calls.Load(); got != 1 {
+		t.Errorf("got %d requests upstream, want 1", got)
+	}
+}
+
+func TestChaosTransportLatency(t *testing.T) {
+	// Latencies of all injected faults add up.
+	base := &stubTransport{body: "ok"}
+	transport := newChaos(base, LatencyFault(1, 10*time.Millisecond), LatencyFault(1, 10*time.Millisecond))
+
+	start := time.Now()
+	if _, err := chaosGet(t, transport, "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("got %v latency, want at least 20ms", elapsed)
+	}
+
+	// A cancelled request stops waiting.
+	transport = newChaos(base, LatencyFault(1, time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://example.com", nil)
+	if _, err := transport.RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want context.DeadlineExceeded", err)
+	}
+	if got := base.calls.Load(); got != 1 {
+		t.Errorf("got %d requests upstream, want 1", got)
+	}
+}
@@ -2,18 +2,14 @@ package backoff
 
 import (
 	"context"
-	"net"
 	"net/http"
 	"net/http/httptrace"
-	"runtime"
-	"time"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/httptrace/otelhttptrace"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
-	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 )
 
@@ -28,15 +24,24 @@ func NewDefaultClientWithOtel(attributes ...attribute.KeyValue) *http.Client {
 }
 
 // NewOAuth2Client creates an HTTP client using OAuth2 credentials.
+// A request rejected with 401 Unauthorized is retried once with a fresh token.
 func NewOAuth2Client(credentials clientcredentials.Config) *http.Client {
-	return credentials.Client(context.Background())
+	return &http.Client{
+		Transport: newAuthTransport(TokenSourceAuth(NewClientCredentialsTokenSource(context.Background(), credentials)), nil),
+	}
 }
 
 // NewOAuth2ClientWithOtel Creates an HTTP client using OAuth2 credentials with OpenTelemetry instrumentation.
+// A request rejected with 401 Unauthorized is retried once with a fresh token.
 func NewOAuth2ClientWithOtel(credentials clientcredentials.Config, attributes ...attribute.KeyValue) *http.Client {
-	client := credentials.Client(context.Background())
-	client.Transport = newInstrumentedTransport(client.Transport, attributes...)
-	return client
+	transport := newAuthTransport(TokenSourceAuth(NewClientCredentialsTokenSource(context.Background(), credentials)), nil)
+	return newClientWithTransport(transport, attributes...)
+}
+
+// NewPooledClientWithAuth returns a pooled HTTP client whose requests are
+// authorized by provider, with OpenTelemetry instrumentation.
+func NewPooledClientWithAuth(provider AuthProvider, attributes ...attribute.KeyValue) *http.Client {
+	return newClientWithTransport(newAuthTransport(provider, NewPooledTransport()), attributes...)
 }
 
 // NewPooledClient returns a new http.Client with similar default values to
@@ -58,15 +63,10 @@ func NewPooledClientWithOtel(attributes ...attribute.KeyValue) *http.Client {
 }
 
 // PooledOAuth2ClientWithOtel combines a pooled HTTP client with OAuth2 authentication and OpenTelemetry instrumentation.
+// A request rejected with 401 Unauthorized is retried once with a fresh token.
 func NewPooledOAuth2ClientWithOtel(credentials clientcredentials.Config, attributes ...attribute.KeyValue) *http.Client {
-	ctx := context.Background()
-	client := credentials.Client(ctx)
-	transporter := &oauth2.Transport{
-		Source: credentials.TokenSource(ctx),
-		Base:   NewPooledTransport(),
-	}
-	client.Transport = newInstrumentedTransport(transporter, attributes...)
-	return client
+	transport := newAuthTransport(TokenSourceAuth(NewClientCredentialsTokenSource(context.Background(), credentials)), NewPooledTransport())
+	return newClientWithTransport(transport, attributes...)
 }
 
 // NewPooledTransport returns a new http.Transport with similar default
@@ -74,28 +74,8 @@ func NewPooledOAuth2ClientWithOtel(credentials clientcredentials.Config, attribu
 // it can leak file descriptors over time. Only use this for transports that
 // will be re-used for the same host(s).
 func NewPooledTransport() *http.Transport {
-	const defaultMaxIdleConns = 100
-	const defaultTimeout = 30 * time.Second
-	const defaultKeepAlive = 30 * time.Second
-	const defaultIdleConnTimeout = 90 * time.Second
-	const defaultTLSHandshakeTimeout = 10 * time.Second
-	const defaultExpectContinueTimeout = 1 * time.Second
-
-	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   defaultTimeout,
-			KeepAlive: defaultKeepAlive,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          defaultMaxIdleConns,
-		IdleConnTimeout:       defaultIdleConnTimeout,
-		TLSHandshakeTimeout:   defaultTLSHandshakeTimeout,
-		ExpectContinueTimeout: defaultExpectContinueTimeout,
-		ForceAttemptHTTP2:     true,
-		MaxIdleConnsPerHost:   runtime.GOMAXPROCS(0) + 1,
-	}
-	return transport
+	cfg := defaultTransportConfig()
+	return newTransport(&cfg)
 }
 
 // WithServiceAttribute sets
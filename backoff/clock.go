@@ -0,0 +1,47 @@
+package backoff
+
+import (
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// Clock tells the time and waits between retries. The retry loop, the
+// backoff strategies, the cache and the signers use it, so that tests can
+// replace it, see package backofftest.
+type Clock interface {
+	Now() time.Time
+
+	// After waits for d to elapse and then sends the current time on the
+	// returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock backed by package time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// newTimer returns the timer of the retry loop, nil for cenkalti's default.
+func newTimer(clock Clock) backoff.Timer {
+	if _, ok := clock.(systemClock); ok {
+		return nil
+	}
+	return &clockTimer{clock: clock}
+}
+
+// clockTimer adapts a Clock to backoff.Timer.
+type clockTimer struct {
+	clock Clock
+	c     <-chan time.Time
+}
+
+func (t *clockTimer) Start(d time.Duration) { t.c = t.clock.After(d) }
+
+func (t *clockTimer) Stop() {}
+
+func (t *clockTimer) C() <-chan time.Time { return t.c }
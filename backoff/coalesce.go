@@ -0,0 +1,103 @@
+package backoff
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// flightGroup shares one in-flight request among concurrent identical callers.
+type flightGroup struct {
+	mu    sync.Mutex // guards calls
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+
+	// resp and err are written before done is closed.
+	resp *Response
+	err  error
+}
+
+// do runs fn once for all concurrent callers with the same key. fn gets a
+// context detached from the callers, which is cancelled once every caller
+// has given up. Each caller waits only as long as its own ctx allows.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(context.Context) (*Response, error)) (*Response, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+
+	call, ok := g.calls[key]
+	if ok {
+		call.waiters++
+	} else {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &flightCall{done: make(chan struct{}), cancel: cancel, waiters: 1}
+		g.calls[key] = call
+
+		go func() {
+			defer cancel()
+			call.resp, call.err = fn(callCtx)
+
+			g.mu.Lock()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return nil, call.err
+		}
+		return call.resp.clone(), nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// coalesceKey identifies identical requests by method, URL, credentials and
+// the values of the given headers.
+func coalesceKey(r *http.Request, headers []string) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte(' ')
+	b.WriteString(r.URL.String())
+	// Credentials are always part of the key, so that callers never get
+	// responses meant for others.
+	for _, name := range append(slices.Clip(credentialHeaders), headers...) {
+		b.WriteByte('\n')
+		b.WriteString(http.CanonicalHeaderKey(name))
+		b.WriteByte(':')
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// clone returns a copy of r that callers may modify independently.
+func (r *Response) clone() *Response {
+	clone := *r
+	clone.Header = r.Header.Clone()
+	clone.Body = bytes.Clone(r.Body)
+	return &clone
+}
@@ -0,0 +1,134 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForWaiters waits until n callers share the in-flight request with key.
+func waitForWaiters(t *testing.T, c *BackoffClient, key string, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.flight.mu.Lock()
+		call := c.flight.calls[key]
+		waiters := 0
+		if call != nil {
+			waiters = call.waiters
+		}
+		c.flight.mu.Unlock()
+
+		if waiters >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d coalesced callers", n)
+}
+
+func TestRequestCoalescing(t *testing.T) {
+	var hits atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	c := NewBackoffClient(WithRequestCoalescing(), WithMaxRetry(1))
+
+	execute := func(authorization string, opts ...Option) (*Response, error) {
+		r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		if authorization != "" {
+			r.Header.Set(AuthorizationHeader, authorization)
+		}
+		return c.Execute(r, opts...)
+	}
+
+	type result struct {
+		resp *Response
+		err  error
+	}
+
+	var wg sync.WaitGroup
+	results := make([]result, 5)
+	run := func(i int, authorization string, opts ...Option) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := execute(authorization, opts...)
+			results[i] = result{resp, err}
+		}()
+	}
+
+	probe, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	probe.Header.Set(AuthorizationHeader, "Bearer a")
+	key := coalesceKey(probe, nil)
+
+	run(0, "Bearer a")
+	run(1, "Bearer a")
+	run(2, "Bearer a")
+	waitForWaiters(t, c, key, 3)
+
+	// Other credentials and per-request options are not coalesced.
+	run(3, "Bearer b")
+	run(4, "Bearer a", WithExpectedStatus(http.StatusOK))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for hits.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if got := hits.Load(); got != 3 {
+		t.Errorf("got %d requests, want 3", got)
+	}
+
+	for i, res := range results[:4] {
+		if res.err != nil || res.resp.StatusCode != http.StatusNotFound {
+			t.Errorf("caller %d: got %v, %v, want 404", i, res.resp, res.err)
+		}
+	}
+
+	var statusErr *StatusError
+	if !errors.As(results[4].err, &statusErr) {
+		t.Errorf("caller with expected status: got error %v, want StatusError", results[4].err)
+	}
+}
+
+func TestRequestCoalescingCancelledCaller(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c := NewBackoffClient(WithRequestCoalescing(), WithMaxRetry(1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Execute(r)
+		done <- err
+	}()
+
+	waitForWaiters(t, c, coalesceKey(r, nil), 1)
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,177 @@
+package backoff
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Codec encodes and decodes bodies of a media type.
+type Codec interface {
+	// ContentType returns the media type the codec is registered for by default.
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// CodecRegistry maps media types to codecs.
+type CodecRegistry struct {
+	mu     sync.RWMutex
+	codecs map[string]Codec
+}
+
+// NewCodecRegistry returns a registry holding the given codecs.
+func NewCodecRegistry(codecs ...Codec) *CodecRegistry {
+	r := &CodecRegistry{codecs: make(map[string]Codec)}
+	for _, codec := range codecs {
+		r.Register(codec)
+	}
+	return r
+}
+
+// DefaultCodecs is the registry used unless WithCodecs is given. It holds
+// the JSON, XML and form codecs.
+var DefaultCodecs = newDefaultCodecs()
+
+func newDefaultCodecs() *CodecRegistry {
+	r := NewCodecRegistry(JSONCodec{}, FormCodec{})
+	r.Register(XMLCodec{}, ContentTypeXML, "text/xml")
+	return r
+}
+
+// RegisterCodec registers a codec in DefaultCodecs, see CodecRegistry.Register.
+func RegisterCodec(codec Codec, mediaTypes ...string) {
+	DefaultCodecs.Register(codec, mediaTypes...)
+}
+
+// Register registers codec for the given media types, or for its own
+// ContentType if there are none, replacing any codec registered before.
+func (r *CodecRegistry) Register(codec Codec, mediaTypes ...string) {
+	if len(mediaTypes) == 0 {
+		mediaTypes = []string{codec.ContentType()}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, mediaType := range mediaTypes {
+		r.codecs[strings.ToLower(mediaType)] = codec
+	}
+}
+
+// Lookup returns the codec for a Content-Type or Accept value. Parameters
+// like charset are ignored, and structured syntax suffixes fall back to
+// their base type, e.g. application/problem+json is handled by the JSON codec.
+func (r *CodecRegistry) Lookup(contentType string) (Codec, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if codec, ok := r.codecs[mediaType]; ok {
+		return codec, true
+	}
+
+	if i := strings.LastIndexByte(mediaType, '+'); i >= 0 {
+		codec, ok := r.codecs["application/"+mediaType[i+1:]]
+		return codec, ok
+	}
+
+	return nil, false
+}
+
+// lookupAccept returns the codec of the first media type of an Accept
+// header value that has one registered.
+func (r *CodecRegistry) lookupAccept(accept string) (Codec, bool) {
+	for _, mediaType := range strings.Split(accept, ",") {
+		if codec, ok := r.Lookup(strings.TrimSpace(mediaType)); ok {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+// marshal encodes v with the codec for contentType.
+func (r *CodecRegistry) marshal(contentType string, v any) ([]byte, error) {
+	codec, ok := r.Lookup(contentType)
+	if !ok {
+		return nil, fmt.Errorf("http-client: no codec registered for %q", contentType)
+	}
+	return codec.Marshal(v)
+}
+
+// JSONCodec encodes bodies with encoding/json.
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string { return ContentTypeJSON }
+
+func (JSONCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// XMLCodec encodes bodies with encoding/xml.
+type XMLCodec struct{}
+
+func (XMLCodec) ContentType() string { return ContentTypeXML }
+
+func (XMLCodec) Marshal(v any) ([]byte, error) { return xml.Marshal(v) }
+
+func (XMLCodec) Unmarshal(data []byte, v any) error { return xml.Unmarshal(data, v) }
+
+// FormCodec encodes url.Values, map[string]string, map[string][]string and
+// structs, see EncodeQueryStruct, as application/x-www-form-urlencoded. It
+// decodes into *url.Values, *map[string][]string and *map[string]string.
+type FormCodec struct{}
+
+func (FormCodec) ContentType() string { return ContentTypeForm }
+
+func (FormCodec) Marshal(v any) ([]byte, error) {
+	switch form := v.(type) {
+	case url.Values:
+		return []byte(form.Encode()), nil
+	case map[string][]string:
+		return []byte(url.Values(form).Encode()), nil
+	case map[string]string:
+		values := url.Values{}
+		for key, value := range form {
+			values.Set(key, value)
+		}
+		return []byte(values.Encode()), nil
+	}
+
+	values, err := EncodeQueryStruct(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(values.Encode()), nil
+}
+
+func (FormCodec) Unmarshal(data []byte, v any) error {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return err
+	}
+
+	switch form := v.(type) {
+	case *url.Values:
+		*form = values
+	case *map[string][]string:
+		*form = values
+	case *map[string]string:
+		*form = make(map[string]string, len(values))
+		for key := range values {
+			(*form)[key] = values.Get(key)
+		}
+	default:
+		return fmt.Errorf("http-client: form codec cannot decode into %T", v)
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+//go:build protobuf
+
+package backoff
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ContentTypeProtobuf is the value for the Content-Type header for protocol buffers.
+const ContentTypeProtobuf = "application/x-protobuf"
+
+func init() {
+	DefaultCodecs.Register(ProtobufCodec{}, ContentTypeProtobuf, "application/protobuf", "application/vnd.google.protobuf")
+}
+
+// ProtobufCodec encodes proto.Message bodies. It is only built with the
+// protobuf build tag.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+func (ProtobufCodec) Marshal(v any) ([]byte, error) {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("http-client: protobuf codec cannot encode %T", v)
+	}
+	return proto.Marshal(message)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v any) error {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("http-client: protobuf codec cannot decode into %T", v)
+	}
+	return proto.Unmarshal(data, message)
+}
@@ -0,0 +1,41 @@
+//go:build protobuf
+
+package backoff
+
+import (
+	"net/http"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestProtobufCodec(t *testing.T) {
+	for _, mediaType := range []string{ContentTypeProtobuf, "application/protobuf", "application/vnd.google.protobuf"} {
+		if codec, ok := DefaultCodecs.Lookup(mediaType); !ok || codec != (ProtobufCodec{}) {
+			t.Errorf("Lookup(%q): got %T, want ProtobufCodec", mediaType, codec)
+		}
+	}
+
+	want := wrapperspb.String("abc")
+	data, err := ProtobufCodec{}.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Response{Header: http.Header{ContentTypeHeader: {ContentTypeProtobuf}}, Body: data}
+	got := new(wrapperspb.StringValue)
+	if err := r.Decode(got); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := (ProtobufCodec{}).Marshal("abc"); err == nil {
+		t.Error("got no error encoding a string")
+	}
+	if err := (ProtobufCodec{}).Unmarshal(data, new(string)); err == nil {
+		t.Error("got no error decoding into a string")
+	}
+}
@@ -0,0 +1,226 @@
+package backoff
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type item struct {
+	ID   int    `json:"id" xml:"id" url:"id"`
+	Name string `json:"name" xml:"name" url:"name"`
+}
+
+// upperCodec is a text codec upper-casing strings, to tell it from others.
+type upperCodec struct{}
+
+func (upperCodec) ContentType() string { return "text/upper" }
+
+func (upperCodec) Marshal(v any) ([]byte, error) {
+	return []byte(strings.ToUpper(v.(string))), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, v any) error {
+	*v.(*string) = strings.ToUpper(string(data))
+	return nil
+}
+
+func TestCodecRegistryLookup(t *testing.T) {
+	codecs := NewCodecRegistry(JSONCodec{}, upperCodec{})
+	codecs.Register(XMLCodec{}, ContentTypeXML, "Text/XML")
+
+	tests := []struct {
+		contentType string
+		want        Codec
+	}{
+		{contentType: ContentTypeJSON, want: JSONCodec{}},
+		{contentType: "application/json; charset=utf-8", want: JSONCodec{}},
+		{contentType: "Application/JSON", want: JSONCodec{}},
+		{contentType: "application/problem+json", want: JSONCodec{}},
+		{contentType: "application/atom+xml", want: XMLCodec{}},
+		{contentType: "text/xml", want: XMLCodec{}},
+		{contentType: "text/upper", want: upperCodec{}},
+		{contentType: "text/plain"},
+		{contentType: "application/vnd.api+yaml"},
+		{contentType: ""},
+		{contentType: "invalid/"},
+	}
+
+	for _, tt := range tests {
+		codec, ok := codecs.Lookup(tt.contentType)
+		if ok != (tt.want != nil) || codec != tt.want {
+			t.Errorf("Lookup(%q): got %T, %v, want %T", tt.contentType, codec, ok, tt.want)
+		}
+	}
+
+	// Registering again replaces the codec.
+	codecs.Register(upperCodec{}, ContentTypeJSON)
+	if codec, _ := codecs.Lookup(ContentTypeJSON); codec != (upperCodec{}) {
+		t.Errorf("got %T after registering again, want upperCodec", codec)
+	}
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	want := item{ID: 1, Name: "a b"}
+
+	for _, codec := range []Codec{JSONCodec{}, XMLCodec{}} {
+		data, err := codec.Marshal(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got item
+		if err := codec.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%T: got %+v, want %+v", codec, got, want)
+		}
+	}
+}
+
+func TestFormCodec(t *testing.T) {
+	for _, v := range []any{
+		url.Values{"id": {"1"}, "name": {"a b"}},
+		map[string][]string{"id": {"1"}, "name": {"a b"}},
+		map[string]string{"id": "1", "name": "a b"},
+		item{ID: 1, Name: "a b"},
+	} {
+		data, err := FormCodec{}.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(data), "id=1&name=a+b"; got != want {
+			t.Errorf("%T: got %q, want %q", v, got, want)
+		}
+	}
+
+	var values url.Values
+	var multi map[string][]string
+	var single map[string]string
+	for v, want := range map[any]any{
+		&values: url.Values{"id": {"1", "2"}},
+		&multi:  map[string][]string{"id": {"1", "2"}},
+		&single: map[string]string{"id": "1"},
+	} {
+		if err := (FormCodec{}).Unmarshal([]byte("id=1&id=2"), v); err != nil {
+			t.Fatal(err)
+		}
+		if got := reflect.ValueOf(v).Elem().Interface(); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	if err := (FormCodec{}).Unmarshal([]byte("id=1"), &item{}); err == nil {
+		t.Error("got no error decoding into a struct")
+	}
+}
+
+func TestResponseDecode(t *testing.T) {
+	codecs := NewCodecRegistry(JSONCodec{}, XMLCodec{}, upperCodec{})
+
+	tests := []struct {
+		name        string
+		contentType string
+		accept      string
+		body        string
+		want        string
+		err         string
+	}{
+		{name: "content type", contentType: "text/upper", body: "abc", want: "ABC"},
+		{name: "content type wins over accept", contentType: ContentTypeJSON, accept: "text/upper", body: `"abc"`, want: "abc"},
+		{name: "unknown content type", contentType: "text/plain", body: `"abc"`, err: `http-client: no codec registered for "text/plain"`},
+		{name: "accept", accept: "text/upper", body: "abc", want: "ABC"},
+		{name: "first registered accept", accept: "text/plain, text/upper;q=0.9, application/json", body: "abc", want: "ABC"},
+		{name: "json fallback", accept: "text/plain", body: `"abc"`, want: "abc"},
+		{name: "no headers", body: `"abc"`, want: "abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Response{Header: http.Header{}, Body: []byte(tt.body), codecs: codecs, accept: tt.accept}
+			if tt.contentType != "" {
+				r.Header.Set(ContentTypeHeader, tt.contentType)
+			}
+
+			var got string
+			err := r.Decode(&got)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	r := &Response{Header: http.Header{}, Body: []byte("abc"), codecs: NewCodecRegistry(upperCodec{})}
+	if err := r.Decode(new(string)); err == nil {
+		t.Error("got no error without a JSON codec to fall back to")
+	}
+}
+
+func TestCodecsNegotiation(t *testing.T) {
+	// The server upper-cases the name of the XML item it is sent.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get(ContentTypeHeader); got != ContentTypeXML {
+			t.Errorf("got request Content-Type %q, want %q", got, ContentTypeXML)
+		}
+
+		var in item
+		if err := xml.Unmarshal(body, &in); err != nil {
+			t.Error(err)
+		}
+		in.Name = strings.ToUpper(in.Name)
+
+		out, _ := XMLCodec{}.Marshal(in)
+		w.Header().Set(ContentTypeHeader, "application/xml; charset=utf-8")
+		w.Write(out)
+	}))
+	defer server.Close()
+
+	c := NewBackoffClient(WithCodecs(NewCodecRegistry(JSONCodec{}, XMLCodec{})))
+	resp, err := c.R().
+		Method(http.MethodPost).
+		URL(server.URL).
+		ContentType(ContentTypeXML).
+		Accept(ContentTypeXML, ContentTypeJSON).
+		BodyEncoded(item{ID: 1, Name: "a"}).
+		Do(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Decode[item](resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 1 || got.Name != "A" {
+		t.Errorf("got %+v, want ID 1 and name A", got)
+	}
+}
+
+func TestRequestBuilderUnknownCodec(t *testing.T) {
+	_, err := NewRequestBuilder().
+		Method(http.MethodPost).
+		URL("https://example.com").
+		ContentType("text/plain").
+		BodyEncoded("abc").
+		Build(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `no codec registered for "text/plain"`) {
+		t.Errorf("got error %v, want no codec registered", err)
+	}
+}
@@ -0,0 +1,198 @@
+package backoff
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// EncodingGzip is the gzip content coding.
+	EncodingGzip = "gzip"
+	// EncodingDeflate is the deflate (zlib) content coding.
+	EncodingDeflate = "deflate"
+	// EncodingBrotli is the brotli content coding.
+	EncodingBrotli = "br"
+	// EncodingZstd is the zstd content coding.
+	EncodingZstd = "zstd"
+
+	// DefaultMaxDecompressedSize is the default limit of a decompressed response body.
+	DefaultMaxDecompressedSize = 32 << 20
+)
+
+var (
+	// ContentEncodingHeader is the key for the Content-Encoding header.
+	ContentEncodingHeader = http.CanonicalHeaderKey("Content-Encoding")
+	// AcceptEncodingHeader is the key for the Accept-Encoding header.
+	AcceptEncodingHeader = http.CanonicalHeaderKey("Accept-Encoding")
+
+	// ErrBodyTooLarge is returned when a decompressed response body exceeds the configured limit.
+	ErrBodyTooLarge = errors.New("http-client: decompressed body too large")
+
+	// acceptEncoding lists the codings decodeBody supports.
+	acceptEncoding = strings.Join([]string{EncodingGzip, EncodingDeflate, EncodingBrotli, EncodingZstd}, ", ")
+)
+
+// zstdEncoder is shared by all requests, EncodeAll is safe for concurrent use.
+var zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+	return zstd.NewWriter(nil)
+})
+
+// compressRequest compresses the body of r with encoding if its length is
+// known and at least minSize. Bodies of unknown length, like multipart
+// streams, and bodies that are already encoded are left alone.
+func compressRequest(r *http.Request, encoding string, minSize int64) error {
+	if r.ContentLength < minSize || r.ContentLength <= 0 || r.Header.Get(ContentEncodingHeader) != "" {
+		return nil
+	}
+
+	body, err := requestBody(r)
+	if err != nil {
+		return err
+	}
+
+	compressed, err := compress(body, encoding)
+	if err != nil {
+		return err
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(compressed))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	r.ContentLength = int64(len(compressed))
+	r.Header.Set(ContentEncodingHeader, encoding)
+	return nil
+}
+
+func compress(data []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case EncodingZstd:
+		encoder, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(data, nil), nil
+	}
+
+	return nil, fmt.Errorf("http-client: unsupported request content encoding %q", encoding)
+}
+
+// decodeBody reads body decoded according to the Content-Encoding list,
+// e.g. "gzip" or "deflate, br", and fails with ErrBodyTooLarge once more
+// than maxSize decoded bytes are read.
+func decodeBody(body io.Reader, contentEncoding string, maxSize int64) ([]byte, error) {
+	var closers []io.Closer
+	defer func() {
+		for _, closer := range closers {
+			closer.Close()
+		}
+	}()
+
+	// Codings are listed in the order they were applied, undo them backwards.
+	encodings := strings.Split(contentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+
+		switch encoding {
+		case "", "identity":
+			continue
+		case EncodingGzip, "x-gzip":
+			r, err := gzip.NewReader(body)
+			if err != nil {
+				return nil, err
+			}
+			closers = append(closers, r)
+			body = r
+		case EncodingDeflate:
+			r, err := newDeflateReader(body)
+			if err != nil {
+				return nil, err
+			}
+			closers = append(closers, r)
+			body = r
+		case EncodingBrotli:
+			body = brotli.NewReader(body)
+		case EncodingZstd:
+			r, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(maxSize)))
+			if err != nil {
+				return nil, err
+			}
+			closers = append(closers, r.IOReadCloser())
+			body = r
+		default:
+			return nil, fmt.Errorf("http-client: unsupported response content encoding %q", encoding)
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxSize+1))
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, maxSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, maxSize)
+	}
+
+	return data, nil
+}
+
+// newDeflateReader reads the zlib format mandated for "deflate", falling
+// back to raw deflate streams that some servers send instead.
+func newDeflateReader(body io.Reader) (io.ReadCloser, error) {
+	buffered := &peekReader{r: body}
+	r, err := zlib.NewReader(buffered)
+	if err == nil {
+		buffered.stop()
+		return r, nil
+	}
+
+	if !errors.Is(err, zlib.ErrHeader) {
+		return nil, err
+	}
+
+	return flate.NewReader(io.MultiReader(bytes.NewReader(buffered.peeked), body)), nil
+}
+
+// peekReader remembers the bytes read from r until stopped.
+type peekReader struct {
+	r       io.Reader
+	peeked  []byte
+	stopped bool
+}
+
+func (p *peekReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if !p.stopped {
+		p.peeked = append(p.peeked, b[:n]...)
+	}
+	return n, err
+}
+
+func (p *peekReader) stop() {
+	p.stopped = true
+	p.peeked = nil
+}
@@ -0,0 +1,257 @@
+package backoff
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encodeBody encodes data with the codings listed in contentEncoding, in order.
+func encodeBody(t *testing.T, data []byte, contentEncoding string) []byte {
+	t.Helper()
+
+	for _, encoding := range strings.Split(contentEncoding, ",") {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		switch strings.TrimSpace(encoding) {
+		case EncodingGzip, "x-gzip":
+			w = gzip.NewWriter(&buf)
+		case EncodingDeflate:
+			w = zlib.NewWriter(&buf)
+		case "raw-deflate":
+			w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		case EncodingBrotli:
+			w = brotli.NewWriter(&buf)
+		case EncodingZstd:
+			w, _ = zstd.NewWriter(&buf)
+		default:
+			t.Fatalf("unknown encoding %q", encoding)
+		}
+
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data = buf.Bytes()
+	}
+	return data
+}
+
+func TestRequestCompression(t *testing.T) {
+	body := strings.Repeat("compressible ", 100)
+
+	tests := []struct {
+		name     string
+		encoding string
+		minSize  int64
+		header   string
+		want     string
+	}{
+		{name: "gzip", encoding: EncodingGzip, minSize: 512, want: EncodingGzip},
+		{name: "zstd", encoding: EncodingZstd, minSize: 512, want: EncodingZstd},
+		{name: "below the minimum size", encoding: EncodingGzip, minSize: int64(len(body)) + 1},
+		{name: "already encoded", encoding: EncodingGzip, header: "identity", want: "identity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				data, _ := io.ReadAll(r.Body)
+
+				if got := r.Header.Get(ContentEncodingHeader); got != tt.want {
+					t.Errorf("attempt %d: got Content-Encoding %q, want %q", attempts, got, tt.want)
+				}
+				if got, want := r.Header.Get("Content-Length"), strconv.Itoa(len(data)); got != want {
+					t.Errorf("attempt %d: got Content-Length %s, want %s", attempts, got, want)
+				}
+
+				if tt.want != "" && tt.want != "identity" {
+					if len(data) >= len(body) {
+						t.Errorf("attempt %d: got %d bytes, want fewer than %d", attempts, len(data), len(body))
+					}
+					decoded, err := decodeBody(bytes.NewReader(data), tt.want, DefaultMaxDecompressedSize)
+					if err != nil {
+						t.Fatal(err)
+					}
+					data = decoded
+				}
+				if string(data) != body {
+					t.Errorf("attempt %d: got body %q", attempts, data)
+				}
+
+				// The compressed body is sent again when retried.
+				if attempts == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer server.Close()
+
+			r, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(body))
+			if tt.header != "" {
+				r.Header.Set(ContentEncodingHeader, tt.header)
+			}
+
+			c := retryingClient(WithRequestCompression(tt.encoding, tt.minSize))
+			if _, err := c.Execute(r); err != nil {
+				t.Fatal(err)
+			}
+			if attempts != 2 {
+				t.Errorf("got %d attempts, want 2", attempts)
+			}
+		})
+	}
+}
+
+func TestRequestCompressionUnsupportedEncoding(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:0", strings.NewReader("body"))
+	_, err := NewBackoffClient(WithRequestCompression(EncodingBrotli, 0)).Execute(r)
+	if err == nil || !strings.Contains(err.Error(), `unsupported request content encoding "br"`) {
+		t.Errorf("got error %v, want unsupported encoding", err)
+	}
+}
+
+func TestResponseDecompression(t *testing.T) {
+	body := []byte(strings.Repeat("compressible ", 100))
+
+	tests := []struct {
+		name     string
+		encoding string
+		header   string
+	}{
+		{name: "gzip", encoding: EncodingGzip},
+		{name: "x-gzip", encoding: "x-gzip"},
+		{name: "deflate", encoding: EncodingDeflate},
+		{name: "raw deflate", encoding: "raw-deflate", header: EncodingDeflate},
+		{name: "brotli", encoding: EncodingBrotli},
+		{name: "zstd", encoding: EncodingZstd},
+		{name: "stacked", encoding: "gzip, br"},
+		{name: "identity", header: "identity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := body
+			if tt.encoding != "" {
+				data = encodeBody(t, body, tt.encoding)
+			}
+			header := tt.header
+			if header == "" {
+				header = tt.encoding
+			}
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get(AcceptEncodingHeader); got != "gzip, deflate, br, zstd" {
+					t.Errorf("got Accept-Encoding %q", got)
+				}
+				w.Header().Set(ContentEncodingHeader, header)
+				w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+				w.Write(data)
+			}))
+			defer server.Close()
+
+			r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			resp, err := NewBackoffClient(WithResponseDecompression(0)).Execute(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(resp.Body, body) {
+				t.Errorf("got body %q", resp.Body)
+			}
+			if got := resp.Header.Get(ContentEncodingHeader); got != "" {
+				t.Errorf("got Content-Encoding %q, want none", got)
+			}
+			if got := resp.Header.Get("Content-Length"); got != "" {
+				t.Errorf("got Content-Length %q, want none", got)
+			}
+		})
+	}
+}
+
+func TestResponseDecompressionErrors(t *testing.T) {
+	body := []byte(strings.Repeat("compressible ", 100))
+
+	tests := []struct {
+		name   string
+		header string
+		data   []byte
+		opts   []Option
+		err    string
+	}{
+		{
+			name:   "too large",
+			header: EncodingGzip,
+			data:   encodeBody(t, body, EncodingGzip),
+			opts:   []Option{WithResponseDecompression(int64(len(body)) - 1)},
+			err:    ErrBodyTooLarge.Error(),
+		},
+		{
+			name:   "unsupported",
+			header: "compress",
+			data:   body,
+			opts:   []Option{WithResponseDecompression(0)},
+			err:    `unsupported response content encoding "compress"`,
+		},
+		{
+			name:   "corrupt",
+			header: EncodingGzip,
+			data:   body,
+			opts:   []Option{WithResponseDecompression(0)},
+			err:    "failed to decompress response",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(ContentEncodingHeader, tt.header)
+				w.Write(tt.data)
+			}))
+			defer server.Close()
+
+			r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			_, err := NewBackoffClient(tt.opts...).Execute(r)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := decodeBody(bytes.NewReader(encodeBody(t, body, EncodingZstd)), EncodingZstd, 10); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("zstd: got error %v, want ErrBodyTooLarge", err)
+	}
+}
+
+func TestResponseWithoutDecompression(t *testing.T) {
+	data := encodeBody(t, []byte("body"), EncodingBrotli)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(ContentEncodingHeader, EncodingBrotli)
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	resp, err := NewBackoffClient().Execute(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(resp.Body, data) || resp.Header.Get(ContentEncodingHeader) != EncodingBrotli {
+		t.Errorf("got body %q and Content-Encoding %q, want them unchanged", resp.Body, resp.Header.Get(ContentEncodingHeader))
+	}
+}
@@ -0,0 +1,425 @@
+package backoff
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultEnvPrefix is the prefix of the environment variables read by
+// Config.LoadEnv, e.g. HTTP_BACKOFF_MAX_RETRY.
+const DefaultEnvPrefix = "HTTP_BACKOFF_"
+
+// Duration is a time.Duration written as a string like "1.5s" in JSON and YAML.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, use a value like 500ms or 2s", text)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Config is the declarative configuration of a BackoffClient, see
+// NewBackoffClientFromConfig. Zero values keep the defaults.
+type Config struct {
+	Service         string            `json:"service,omitempty" yaml:"service,omitempty"`
+	BaseURL         string            `json:"base_url,omitempty" yaml:"base_url,omitempty"`
+	UserAgent       string            `json:"user_agent,omitempty" yaml:"user_agent,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	MaxRetry        int               `json:"max_retry,omitempty" yaml:"max_retry,omitempty"`
+	InitialInterval Duration          `json:"initial_interval,omitempty" yaml:"initial_interval,omitempty"`
+	MaxInterval     Duration          `json:"max_interval,omitempty" yaml:"max_interval,omitempty"`
+	Multiplier      float64           `json:"multiplier,omitempty" yaml:"multiplier,omitempty"`
+	Timeout         Duration          `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	ExpectedStatus  []int             `json:"expected_status,omitempty" yaml:"expected_status,omitempty"`
+
+	Transport TransportConfig `json:"transport" yaml:"transport,omitempty"`
+	TLS       TLSConfig       `json:"tls" yaml:"tls,omitempty"`
+}
+
+// TransportConfig configures the transport, see NewTransport.
+type TransportConfig struct {
+	DialTimeout           Duration `json:"dial_timeout,omitempty" yaml:"dial_timeout,omitempty"`
+	KeepAlive             Duration `json:"keep_alive,omitempty" yaml:"keep_alive,omitempty"`
+	MaxIdleConns          int      `json:"max_idle_conns,omitempty" yaml:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost   int      `json:"max_idle_conns_per_host,omitempty" yaml:"max_idle_conns_per_host,omitempty"`
+	MaxConnsPerHost       int      `json:"max_conns_per_host,omitempty" yaml:"max_conns_per_host,omitempty"`
+	IdleConnTimeout       Duration `json:"idle_conn_timeout,omitempty" yaml:"idle_conn_timeout,omitempty"`
+	TLSHandshakeTimeout   Duration `json:"tls_handshake_timeout,omitempty" yaml:"tls_handshake_timeout,omitempty"`
+	ResponseHeaderTimeout Duration `json:"response_header_timeout,omitempty" yaml:"response_header_timeout,omitempty"`
+	ProxyURL              string   `json:"proxy_url,omitempty" yaml:"proxy_url,omitempty"`
+}
+
+// TLSConfig configures TLS, see NewTLSConfig.
+type TLSConfig struct {
+	SystemCAs  bool     `json:"system_cas,omitempty" yaml:"system_cas,omitempty"`
+	CAFile     string   `json:"ca_file,omitempty" yaml:"ca_file,omitempty"`
+	CertFile   string   `json:"cert_file,omitempty" yaml:"cert_file,omitempty"`
+	KeyFile    string   `json:"key_file,omitempty" yaml:"key_file,omitempty"`
+	ServerName string   `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+	MinVersion string   `json:"min_version,omitempty" yaml:"min_version,omitempty"`
+	PinnedSPKI []string `json:"pinned_spki,omitempty" yaml:"pinned_spki,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// LoadConfig reads a Config from a JSON or YAML file, told apart by the
+// .json, .yaml or .yml extension. Unknown fields are rejected.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := new(Config)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(cfg)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(cfg)
+	default:
+		return nil, fmt.Errorf("http-client: config %s: unsupported format %q", path, ext)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("http-client: config %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// LoadEnv overlays the configuration with environment variables named after
+// the prefix, DefaultEnvPrefix if empty, and the upper-cased JSON field
+// names, e.g. HTTP_BACKOFF_MAX_RETRY=3, HTTP_BACKOFF_TIMEOUT=2s or
+// HTTP_BACKOFF_TLS_CA_FILE=/etc/ssl/ca.pem. Lists are comma separated and
+// headers are given as HTTP_BACKOFF_HEADERS="X-Team=payments,X-Env=prod".
+func (c *Config) LoadEnv(prefix string) error {
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+	return loadEnv(reflect.ValueOf(c).Elem(), prefix)
+}
+
+func loadEnv(v reflect.Value, prefix string) error {
+	var errs []error
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		key := prefix + strings.ToUpper(name)
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := loadEnv(v.Field(i), key+"_"); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+
+		if err := setEnvValue(v.Field(i), value); err != nil {
+			errs = append(errs, fmt.Errorf("http-client: %s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setEnvValue(v reflect.Value, value string) error {
+	if unmarshaler, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(value))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		items := strings.Split(value, ",")
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setEnvValue(slice.Index(i), strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Map:
+		m := make(map[string]string)
+		for _, item := range strings.Split(value, ",") {
+			key, val, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("invalid entry %q, use key=value", item)
+			}
+			m[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// Validate reports every invalid value of the configuration.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("http-client: config: "+format, args...))
+	}
+
+	if c.BaseURL != "" {
+		if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("base_url %q is not an absolute http(s) URL", c.BaseURL)
+		}
+	}
+
+	if c.MaxRetry < 0 {
+		invalid("max_retry must not be negative, got %d", c.MaxRetry)
+	}
+
+	if c.Multiplier < 0 || (c.Multiplier > 0 && c.Multiplier < 1) {
+		invalid("multiplier must be at least 1, got %g", c.Multiplier)
+	}
+
+	durations := []struct {
+		name string
+		d    Duration
+	}{
+		{"initial_interval", c.InitialInterval},
+		{"max_interval", c.MaxInterval},
+		{"timeout", c.Timeout},
+		{"transport.dial_timeout", c.Transport.DialTimeout},
+		{"transport.keep_alive", c.Transport.KeepAlive},
+		{"transport.idle_conn_timeout", c.Transport.IdleConnTimeout},
+		{"transport.tls_handshake_timeout", c.Transport.TLSHandshakeTimeout},
+		{"transport.response_header_timeout", c.Transport.ResponseHeaderTimeout},
+	}
+	for _, duration := range durations {
+		if duration.d < 0 {
+			invalid("%s must not be negative, got %s", duration.name, time.Duration(duration.d))
+		}
+	}
+
+	if c.InitialInterval > 0 && c.MaxInterval > 0 && c.InitialInterval > c.MaxInterval {
+		invalid("initial_interval %s exceeds max_interval %s", time.Duration(c.InitialInterval), time.Duration(c.MaxInterval))
+	}
+
+	for _, code := range c.ExpectedStatus {
+		if code < 100 || code > 599 {
+			invalid("expected_status %d is not a valid status code", code)
+		}
+	}
+
+	counts := []struct {
+		name string
+		n    int
+	}{
+		{"transport.max_idle_conns", c.Transport.MaxIdleConns},
+		{"transport.max_idle_conns_per_host", c.Transport.MaxIdleConnsPerHost},
+		{"transport.max_conns_per_host", c.Transport.MaxConnsPerHost},
+	}
+	for _, count := range counts {
+		if count.n < 0 {
+			invalid("%s must not be negative, got %d", count.name, count.n)
+		}
+	}
+
+	if c.Transport.ProxyURL != "" {
+		if u, err := url.Parse(c.Transport.ProxyURL); err != nil || u.Host == "" {
+			invalid("transport.proxy_url %q is not a valid URL", c.Transport.ProxyURL)
+		}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		invalid("tls.cert_file and tls.key_file must be set together")
+	}
+
+	if _, ok := tlsVersions[c.TLS.MinVersion]; c.TLS.MinVersion != "" && !ok {
+		invalid("tls.min_version %q must be one of 1.0, 1.1, 1.2 or 1.3", c.TLS.MinVersion)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Options validates the configuration and returns the matching options.
+func (c *Config) Options() ([]Option, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	var opts []Option
+	if c.Service != "" {
+		opts = append(opts, WithService(c.Service))
+	}
+	if c.BaseURL != "" {
+		opts = append(opts, WithBaseURL(c.BaseURL))
+	}
+	if c.UserAgent != "" {
+		opts = append(opts, WithUserAgent(c.UserAgent))
+	}
+	if len(c.Headers) > 0 {
+		opts = append(opts, WithHeaders(c.Headers))
+	}
+	if c.MaxRetry > 0 {
+		opts = append(opts, WithMaxRetry(uint64(c.MaxRetry)))
+	}
+	if c.InitialInterval > 0 {
+		opts = append(opts, WithInitialInterval(time.Duration(c.InitialInterval)))
+	}
+	if c.MaxInterval > 0 {
+		opts = append(opts, WithMaxInterval(time.Duration(c.MaxInterval)))
+	}
+	if c.Multiplier > 0 {
+		opts = append(opts, WithMultiplier(c.Multiplier))
+	}
+	if c.Timeout > 0 {
+		opts = append(opts, WithTimeout(time.Duration(c.Timeout)))
+	}
+	if len(c.ExpectedStatus) > 0 {
+		opts = append(opts, WithExpectedStatus(c.ExpectedStatus...))
+	}
+
+	transportOpts, err := c.transportOptions()
+	if err != nil {
+		return nil, err
+	}
+	if len(transportOpts) > 0 {
+		transport, err := NewTransport(transportOpts...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithClient(&http.Client{Transport: transport}))
+	}
+
+	return opts, nil
+}
+
+func (c *Config) transportOptions() ([]TransportOption, error) {
+	var opts []TransportOption
+	t := c.Transport
+	if t.DialTimeout > 0 {
+		opts = append(opts, WithDialTimeout(time.Duration(t.DialTimeout)))
+	}
+	if t.KeepAlive > 0 {
+		opts = append(opts, WithKeepAlive(time.Duration(t.KeepAlive)))
+	}
+	if t.MaxIdleConns > 0 {
+		opts = append(opts, WithMaxIdleConns(t.MaxIdleConns))
+	}
+	if t.MaxIdleConnsPerHost > 0 {
+		opts = append(opts, WithMaxIdleConnsPerHost(t.MaxIdleConnsPerHost))
+	}
+	if t.MaxConnsPerHost > 0 {
+		opts = append(opts, WithMaxConnsPerHost(t.MaxConnsPerHost))
+	}
+	if t.IdleConnTimeout > 0 {
+		opts = append(opts, WithIdleConnTimeout(time.Duration(t.IdleConnTimeout)))
+	}
+	if t.TLSHandshakeTimeout > 0 {
+		opts = append(opts, WithTLSHandshakeTimeout(time.Duration(t.TLSHandshakeTimeout)))
+	}
+	if t.ResponseHeaderTimeout > 0 {
+		opts = append(opts, WithResponseHeaderTimeout(time.Duration(t.ResponseHeaderTimeout)))
+	}
+	if t.ProxyURL != "" {
+		proxyURL, err := url.Parse(t.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithProxyURL(proxyURL))
+	}
+
+	tlsOpts := c.tlsOptions()
+	if len(tlsOpts) > 0 {
+		tlsConfig, err := NewTLSConfig(tlsOpts...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithTLSConfig(tlsConfig))
+	}
+
+	return opts, nil
+}
+
+func (c *Config) tlsOptions() []TLSOption {
+	var opts []TLSOption
+	t := c.TLS
+	// System CAs must come before the CA bundle.
+	if t.SystemCAs {
+		opts = append(opts, WithSystemCAs())
+	}
+	if t.CAFile != "" {
+		opts = append(opts, WithCAFile(t.CAFile))
+	}
+	if t.CertFile != "" {
+		opts = append(opts, WithClientCertificate(t.CertFile, t.KeyFile))
+	}
+	if t.ServerName != "" {
+		opts = append(opts, WithServerName(t.ServerName))
+	}
+	if t.MinVersion != "" {
+		opts = append(opts, WithMinTLSVersion(tlsVersions[t.MinVersion]))
+	}
+	if len(t.PinnedSPKI) > 0 {
+		opts = append(opts, WithPinnedSPKI(t.PinnedSPKI...))
+	}
+	return opts
+}
+
+// NewBackoffClientFromConfig returns a BackoffClient configured by cfg. The
+// options are applied after the configuration, e.g. for hooks and policies
+// that cannot be expressed declaratively.
+func NewBackoffClientFromConfig(cfg *Config, opts ...Option) (*BackoffClient, error) {
+	cfgOpts, err := cfg.Options()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBackoffClient(append(cfgOpts, opts...)...), nil
+}
@@ -0,0 +1,327 @@
+package backoff
+
+import (
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fullConfig is the configuration in testdata/config/full.{json,yaml}.
+var fullConfig = Config{
+	Service:         "orders",
+	BaseURL:         "https://api.example.com/v1/",
+	UserAgent:       "orders-client/1.0",
+	Headers:         map[string]string{"X-Team": "payments"},
+	MaxRetry:        4,
+	InitialInterval: Duration(100 * time.Millisecond),
+	MaxInterval:     Duration(2 * time.Second),
+	Multiplier:      2,
+	Timeout:         Duration(5 * time.Second),
+	ExpectedStatus:  []int{200, 204},
+	Transport: TransportConfig{
+		DialTimeout:  Duration(3 * time.Second),
+		MaxIdleConns: 50,
+		ProxyURL:     "http://proxy.example.com:3128",
+	},
+	TLS: TLSConfig{
+		ServerName: "api.example.com",
+		MinVersion: "1.2",
+	},
+}
+
+func configFile(name string) string {
+	return filepath.Join("testdata", "config", name)
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		file string
+		want *Config
+		err  string
+	}{
+		{file: "full.yaml", want: &fullConfig},
+		{file: "full.json", want: &fullConfig},
+		{file: "numeric_timeout.yaml", err: `invalid duration "2", use a value like 500ms or 2s`},
+		{file: "unknown_field.json", err: `unknown field "max_retries"`},
+		{file: "unknown_field.yaml", err: "field max_retries not found"},
+		{file: "config.toml", err: `unsupported format ".toml"`},
+		{file: "missing.yaml", err: "no such file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			cfg, err := LoadConfig(configFile(tt.file))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want it to contain %q", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg, tt.want) {
+				t.Errorf("got %+v, want %+v", cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigLoadEnv(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		env    map[string]string
+		want   func(cfg *Config)
+		errs   []string
+	}{
+		{
+			name: "overrides the file",
+			env: map[string]string{
+				"HTTP_BACKOFF_MAX_RETRY":              "7",
+				"HTTP_BACKOFF_TIMEOUT":                "1m",
+				"HTTP_BACKOFF_MULTIPLIER":             "1.5",
+				"HTTP_BACKOFF_HEADERS":                "X-Env=prod, X-Team=risk",
+				"HTTP_BACKOFF_EXPECTED_STATUS":        "200, 201",
+				"HTTP_BACKOFF_TRANSPORT_DIAL_TIMEOUT": "1s",
+				"HTTP_BACKOFF_TLS_SYSTEM_CAS":         "true",
+				"HTTP_BACKOFF_TLS_PINNED_SPKI":        "a,b",
+			},
+			want: func(cfg *Config) {
+				cfg.MaxRetry = 7
+				cfg.Timeout = Duration(time.Minute)
+				cfg.Multiplier = 1.5
+				cfg.Headers = map[string]string{"X-Env": "prod", "X-Team": "risk"}
+				cfg.ExpectedStatus = []int{200, 201}
+				cfg.Transport.DialTimeout = Duration(time.Second)
+				cfg.TLS.SystemCAs = true
+				cfg.TLS.PinnedSPKI = []string{"a", "b"}
+			},
+		},
+		{
+			name:   "custom prefix",
+			prefix: "ORDERS_",
+			env: map[string]string{
+				"ORDERS_SERVICE":         "billing",
+				"HTTP_BACKOFF_MAX_RETRY": "9",
+			},
+			want: func(cfg *Config) {
+				cfg.Service = "billing"
+			},
+		},
+		{
+			name: "invalid duration",
+			env:  map[string]string{"HTTP_BACKOFF_TIMEOUT": "abc"},
+			errs: []string{`HTTP_BACKOFF_TIMEOUT: invalid duration "abc"`},
+		},
+		{
+			name: "invalid integer",
+			env:  map[string]string{"HTTP_BACKOFF_TRANSPORT_MAX_IDLE_CONNS": "many"},
+			errs: []string{`HTTP_BACKOFF_TRANSPORT_MAX_IDLE_CONNS: invalid integer "many"`},
+		},
+		{
+			name: "invalid header",
+			env:  map[string]string{"HTTP_BACKOFF_HEADERS": "X-Env"},
+			errs: []string{`HTTP_BACKOFF_HEADERS: invalid entry "X-Env", use key=value`},
+		},
+		{
+			name: "every error",
+			env: map[string]string{
+				"HTTP_BACKOFF_TIMEOUT":        "abc",
+				"HTTP_BACKOFF_TLS_SYSTEM_CAS": "maybe",
+			},
+			errs: []string{
+				`HTTP_BACKOFF_TIMEOUT: invalid duration "abc"`,
+				`HTTP_BACKOFF_TLS_SYSTEM_CAS: invalid boolean "maybe"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := LoadConfig(configFile("full.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = cfg.LoadEnv(tt.prefix)
+			if len(tt.errs) > 0 {
+				for _, want := range tt.errs {
+					if err == nil || !strings.Contains(err.Error(), want) {
+						t.Errorf("got error %v, want it to contain %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := fullConfig
+			tt.want(&want)
+			if !reflect.DeepEqual(*cfg, want) {
+				t.Errorf("got %+v, want %+v", *cfg, want)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		errs   []string
+	}{
+		{name: "valid", modify: func(*Config) {}},
+		{name: "zero", modify: func(cfg *Config) { *cfg = Config{} }},
+		{
+			name:   "relative base url",
+			modify: func(cfg *Config) { cfg.BaseURL = "/v1" },
+			errs:   []string{`base_url "/v1" is not an absolute http(s) URL`},
+		},
+		{
+			name:   "negative max retry",
+			modify: func(cfg *Config) { cfg.MaxRetry = -1 },
+			errs:   []string{"max_retry must not be negative, got -1"},
+		},
+		{
+			name:   "negative multiplier",
+			modify: func(cfg *Config) { cfg.Multiplier = -2 },
+			errs:   []string{"multiplier must be at least 1, got -2"},
+		},
+		{
+			name:   "multiplier below one",
+			modify: func(cfg *Config) { cfg.Multiplier = 0.5 },
+			errs:   []string{"multiplier must be at least 1, got 0.5"},
+		},
+		{
+			name:   "initial interval above max interval",
+			modify: func(cfg *Config) { cfg.InitialInterval = Duration(3 * time.Second) },
+			errs:   []string{"initial_interval 3s exceeds max_interval 2s"},
+		},
+		{
+			name:   "negative duration",
+			modify: func(cfg *Config) { cfg.Transport.DialTimeout = Duration(-time.Second) },
+			errs:   []string{"transport.dial_timeout must not be negative, got -1s"},
+		},
+		{
+			name:   "invalid status code",
+			modify: func(cfg *Config) { cfg.ExpectedStatus = []int{200, 42} },
+			errs:   []string{"expected_status 42 is not a valid status code"},
+		},
+		{
+			name:   "negative connection count",
+			modify: func(cfg *Config) { cfg.Transport.MaxConnsPerHost = -1 },
+			errs:   []string{"transport.max_conns_per_host must not be negative, got -1"},
+		},
+		{
+			name:   "invalid proxy url",
+			modify: func(cfg *Config) { cfg.Transport.ProxyURL = "proxy" },
+			errs:   []string{`transport.proxy_url "proxy" is not a valid URL`},
+		},
+		{
+			name:   "cert file without key file",
+			modify: func(cfg *Config) { cfg.TLS.CertFile = "client.pem" },
+			errs:   []string{"tls.cert_file and tls.key_file must be set together"},
+		},
+		{
+			name:   "key file without cert file",
+			modify: func(cfg *Config) { cfg.TLS.KeyFile = "client.key" },
+			errs:   []string{"tls.cert_file and tls.key_file must be set together"},
+		},
+		{
+			name:   "unknown TLS version",
+			modify: func(cfg *Config) { cfg.TLS.MinVersion = "1.4" },
+			errs:   []string{`tls.min_version "1.4" must be one of 1.0, 1.1, 1.2 or 1.3`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := fullConfig
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Errorf("got error %v", err)
+				}
+				return
+			}
+
+			for _, want := range tt.errs {
+				if err == nil || !strings.Contains(err.Error(), "http-client: config: "+want) {
+					t.Errorf("got error %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigValidateReportsEveryError(t *testing.T) {
+	cfg, err := LoadConfig(configFile("invalid.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cfg.Validate()
+	for _, want := range []string{
+		"base_url",
+		"max_retry",
+		"multiplier",
+		"initial_interval 5s exceeds max_interval 1s",
+		"expected_status 42",
+		"tls.cert_file and tls.key_file",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v, want it to mention %q", err, want)
+		}
+	}
+}
+
+func TestNewBackoffClientFromConfig(t *testing.T) {
+	cfg, err := LoadConfig(configFile("full.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewBackoffClientFromConfig(cfg, WithMaxRetry(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Options passed along are applied after the configuration.
+	if c.cfg.maxRetry != 2 {
+		t.Errorf("got max retry %d, want 2", c.cfg.maxRetry)
+	}
+	if c.cfg.baseURL != cfg.BaseURL || c.cfg.userAgent != cfg.UserAgent || c.cfg.multiplier != cfg.Multiplier {
+		t.Errorf("got base URL %q, user agent %q and multiplier %g, want the configured ones", c.cfg.baseURL, c.cfg.userAgent, c.cfg.multiplier)
+	}
+	if c.cfg.initialInterval != 100*time.Millisecond || c.cfg.maxInterval != 2*time.Second {
+		t.Errorf("got intervals %v and %v, want 100ms and 2s", c.cfg.initialInterval, c.cfg.maxInterval)
+	}
+	if _, ok := c.cfg.expectedStatus[http.StatusNoContent]; !ok || len(c.cfg.expectedStatus) != 2 {
+		t.Errorf("got expected status %v, want 200 and 204", c.cfg.expectedStatus)
+	}
+
+	transport, ok := c.Client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("got transport %T, want *http.Transport", c.Client.Transport)
+	}
+	if transport.MaxIdleConns != 50 || transport.TLSClientConfig == nil || transport.TLSClientConfig.ServerName != "api.example.com" {
+		t.Errorf("got transport %+v, want the configured one", transport)
+	}
+
+	cfg.MaxRetry = -1
+	if _, err := NewBackoffClientFromConfig(cfg); err == nil {
+		t.Error("invalid config: got no error")
+	}
+}
@@ -0,0 +1,238 @@
+package backoff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ContentTypeMultipart is the value for the Content-Type header for multipart form data, without boundary.
+const ContentTypeMultipart = "multipart/form-data"
+
+// ErrMultipartReplay is returned when a multipart body built from a reader
+// that cannot seek has to be sent again.
+var ErrMultipartReplay = errors.New("http-client: multipart part cannot be replayed")
+
+// MultipartPart is a single part of a multipart/form-data body.
+type MultipartPart struct {
+	// FieldName is the form field name.
+	FieldName string
+
+	// FileName is the file name, empty for plain fields.
+	FileName string
+
+	// ContentType is the part's Content-Type, left out if empty.
+	ContentType string
+
+	// Open returns the part content. It is called every time the body is
+	// sent, so it must start from the beginning each time. A returned
+	// io.Closer is closed once the content has been copied.
+	Open func() (io.Reader, error)
+}
+
+// multipartBody is a multipart/form-data body that is streamed through an
+// io.Pipe, so large files are never buffered in memory, and that can be
+// recreated for every attempt.
+type multipartBody struct {
+	boundary string
+	parts    []MultipartPart
+}
+
+func newMultipartBody() *multipartBody {
+	// The boundary stays the same across attempts so that the Content-Type
+	// header set once by Build matches every replayed body.
+	return &multipartBody{
+		boundary: multipart.NewWriter(io.Discard).Boundary(),
+	}
+}
+
+func (m *multipartBody) contentType() string {
+	return mime.FormatMediaType(ContentTypeMultipart, map[string]string{"boundary": m.boundary})
+}
+
+// open returns a new stream of the body. The parts are only opened once the
+// stream is read.
+func (m *multipartBody) open() (io.ReadCloser, error) {
+	return &multipartStream{body: m}, nil
+}
+
+func (m *multipartBody) write(w io.Writer) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(m.boundary); err != nil {
+		return err
+	}
+
+	for _, part := range m.parts {
+		if err := writePart(mw, part); err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+func writePart(mw *multipart.Writer, part MultipartPart) error {
+	disposition := fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(part.FieldName))
+	if part.FileName != "" {
+		disposition += fmt.Sprintf(`; filename="%s"`, escapeQuotes(part.FileName))
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", disposition)
+	if part.ContentType != "" {
+		header.Set(ContentTypeHeader, part.ContentType)
+	}
+
+	w, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	r, err := part.Open()
+	if err != nil {
+		return fmt.Errorf("http-client: multipart field %q: %w", part.FieldName, err)
+	}
+
+	if closer, ok := r.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+// multipartStream starts writing the body into a pipe on the first Read.
+type multipartStream struct {
+	body *multipartBody
+	once sync.Once
+	pr   *io.PipeReader
+}
+
+func (s *multipartStream) start() {
+	pr, pw := io.Pipe()
+	s.pr = pr
+	go func() {
+		pw.CloseWithError(s.body.write(pw))
+	}()
+}
+
+// streaming marks the stream as never buffered, signers leave it alone.
+func (s *multipartStream) streaming() {}
+
+func (s *multipartStream) Read(p []byte) (int, error) {
+	s.once.Do(s.start)
+	return s.pr.Read(p)
+}
+
+func (s *multipartStream) Close() error {
+	s.once.Do(func() {
+		// Closed before being read, never start the writer.
+		s.pr, _ = io.Pipe()
+	})
+	return s.pr.Close()
+}
+
+// MultipartField adds a plain form field to the multipart body.
+func (rb *RequestBuilder) MultipartField(name, value string) *RequestBuilder {
+	return rb.MultipartPart(MultipartPart{
+		FieldName: name,
+		Open: func() (io.Reader, error) {
+			return strings.NewReader(value), nil
+		},
+	})
+}
+
+// MultipartFile adds a file from disk to the multipart body. The content type
+// is guessed from the file extension. The file is opened again for every attempt.
+func (rb *RequestBuilder) MultipartFile(field, path string) *RequestBuilder {
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = ContentTypeOctetStream
+	}
+
+	return rb.MultipartPart(MultipartPart{
+		FieldName:   field,
+		FileName:    filepath.Base(path),
+		ContentType: contentType,
+		Open: func() (io.Reader, error) {
+			return os.Open(path)
+		},
+	})
+}
+
+// MultipartReader adds a file read from r to the multipart body. If r is an
+// io.Seeker it is rewound for every attempt, otherwise the body cannot be
+// sent again and retries fail with ErrMultipartReplay. Like every multipart
+// body it is streamed, see Signer for how signers deal with it.
+func (rb *RequestBuilder) MultipartReader(field, fileName, contentType string, r io.Reader) *RequestBuilder {
+	if contentType == "" {
+		contentType = ContentTypeOctetStream
+	}
+
+	return rb.MultipartPart(MultipartPart{
+		FieldName:   field,
+		FileName:    fileName,
+		ContentType: contentType,
+		Open:        replayableReader(r),
+	})
+}
+
+// MultipartPart adds a part to the multipart body.
+func (rb *RequestBuilder) MultipartPart(part MultipartPart) *RequestBuilder {
+	if part.Open == nil {
+		rb.errs = append(rb.errs, fmt.Errorf("http-client: multipart field %q has no content", part.FieldName))
+		return rb
+	}
+
+	if rb.multipart == nil {
+		rb.multipart = newMultipartBody()
+	}
+
+	rb.multipart.parts = append(rb.multipart.parts, part)
+	return rb
+}
+
+// replayableReader returns an opener that rewinds r to its current offset, or
+// hands it out only once if it cannot seek.
+func replayableReader(r io.Reader) func() (io.Reader, error) {
+	if seeker, ok := r.(io.Seeker); ok {
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		return func() (io.Reader, error) {
+			if err != nil {
+				return nil, err
+			}
+
+			if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(r), nil
+		}
+	}
+
+	var mu sync.Mutex
+	used := false
+	return func() (io.Reader, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if used {
+			return nil, ErrMultipartReplay
+		}
+
+		used = true
+		return io.NopCloser(r), nil
+	}
+}
@@ -0,0 +1,122 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// multipartServer records the file parts it receives and answers with the
+// given status codes in order, the last one repeated.
+type multipartServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	files   []string
+	headers []http.Header
+}
+
+func newMultipartServer(t *testing.T, codes ...int) *multipartServer {
+	t.Helper()
+
+	s := &multipartServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file := ""
+		if err := r.ParseMultipartForm(1 << 20); err == nil {
+			if f, _, err := r.FormFile("file"); err == nil {
+				data, _ := io.ReadAll(f)
+				file = string(data)
+			}
+		}
+
+		s.mu.Lock()
+		s.files = append(s.files, file)
+		s.headers = append(s.headers, r.Header.Clone())
+		code := codes[min(len(s.files), len(codes))-1]
+		s.mu.Unlock()
+
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// onlyReader hides every method of its reader but Read, so it cannot seek.
+type onlyReader struct{ io.Reader }
+
+func TestMultipartReaderReplay(t *testing.T) {
+	c := NewBackoffClient(WithMaxRetry(2))
+
+	t.Run("seekable", func(t *testing.T) {
+		server := newMultipartServer(t, http.StatusServiceUnavailable, http.StatusOK)
+
+		_, err := c.R().URL(server.URL).
+			MultipartField("name", "report").
+			MultipartReader("file", "report.txt", ContentTypeText, strings.NewReader("content")).
+			Do(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Join(server.files, ",") != "content,content" {
+			t.Errorf("got files %q, want the content twice", server.files)
+		}
+	})
+
+	t.Run("not seekable", func(t *testing.T) {
+		server := newMultipartServer(t, http.StatusServiceUnavailable, http.StatusOK)
+
+		_, err := c.R().URL(server.URL).
+			MultipartReader("file", "report.txt", ContentTypeText, onlyReader{strings.NewReader("content")}).
+			Do(context.Background())
+		if !errors.Is(err, ErrMultipartReplay) {
+			t.Errorf("got error %v, want ErrMultipartReplay", err)
+		}
+	})
+}
+
+func TestMultipartSigning(t *testing.T) {
+	t.Run("SigV4 unsigned payload", func(t *testing.T) {
+		server := newMultipartServer(t, http.StatusOK)
+		c := NewBackoffClient(WithMaxRetry(1), WithSigner(NewSigV4Signer("AKIDEXAMPLE", "secret", "us-east-1", "s3")))
+
+		// A reader that cannot seek is only read once, while being sent.
+		_, err := c.R().URL(server.URL).
+			MultipartReader("file", "report.txt", ContentTypeText, onlyReader{strings.NewReader("content")}).
+			Do(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(server.files) != 1 || server.files[0] != "content" {
+			t.Fatalf("got files %q, want one upload of the content", server.files)
+		}
+		header := server.headers[0]
+		if got := header.Get("X-Amz-Content-Sha256"); got != "UNSIGNED-PAYLOAD" {
+			t.Errorf("got X-Amz-Content-Sha256 %q, want UNSIGNED-PAYLOAD", got)
+		}
+		if !strings.HasPrefix(header.Get(AuthorizationHeader), "AWS4-HMAC-SHA256 ") {
+			t.Errorf("got Authorization %q, want a SigV4 signature", header.Get(AuthorizationHeader))
+		}
+	})
+
+	t.Run("HMAC", func(t *testing.T) {
+		server := newMultipartServer(t, http.StatusOK)
+		c := NewBackoffClient(WithMaxRetry(1), WithSigner(NewHMACSigner("key", []byte("secret"))))
+
+		_, err := c.R().URL(server.URL).
+			MultipartReader("file", "report.txt", ContentTypeText, onlyReader{strings.NewReader("content")}).
+			Do(context.Background())
+		if !errors.Is(err, ErrStreamingBody) {
+			t.Errorf("got error %v, want ErrStreamingBody", err)
+		}
+		if len(server.files) != 0 {
+			t.Errorf("got %d requests, want none", len(server.files))
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package backoff
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/oauth2/jwt"
+)
+
+// NewClientCredentialsTokenSource returns a token source for the OAuth2
+// client-credentials flow.
+func NewClientCredentialsTokenSource(ctx context.Context, credentials clientcredentials.Config) oauth2.TokenSource {
+	return newReuseTokenSource(tokenSourceFunc(func() (*oauth2.Token, error) {
+		return credentials.Token(ctx)
+	}))
+}
+
+// NewRefreshTokenSource returns a token source for the OAuth2 refresh-token
+// flow. Rotated refresh tokens returned by the server are used for the
+// following refreshes.
+func NewRefreshTokenSource(ctx context.Context, cfg *oauth2.Config, refreshToken string) oauth2.TokenSource {
+	var mu sync.Mutex
+	return newReuseTokenSource(tokenSourceFunc(func() (*oauth2.Token, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		token, err := cfg.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+		if err != nil {
+			return nil, err
+		}
+
+		if token.RefreshToken != "" {
+			refreshToken = token.RefreshToken
+		}
+
+		return token, nil
+	}))
+}
+
+// NewJWTTokenSource returns a token source for the OAuth2 JWT-bearer flow
+// (RFC 7523).
+func NewJWTTokenSource(ctx context.Context, cfg *jwt.Config) oauth2.TokenSource {
+	return newReuseTokenSource(tokenSourceFunc(func() (*oauth2.Token, error) {
+		return cfg.TokenSource(ctx).Token()
+	}))
+}
+
+// NewFileTokenSource returns a token source that reads the token from a file
+// and reloads it whenever the file is rotated on disk. The file holds either
+// a raw bearer token or a JSON encoded oauth2.Token.
+func NewFileTokenSource(path string) oauth2.TokenSource {
+	return &fileTokenSource{path: path}
+}
+
+type fileTokenSource struct {
+	path string
+
+	mu      sync.Mutex // guards the fields below
+	token   *oauth2.Token
+	modTime time.Time
+	size    int64
+}
+
+// Token returns the token from the file, reading it again if the file changed.
+func (s *fileTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("http-client: token file: %w", err)
+	}
+
+	if s.token != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.token, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("http-client: token file: %w", err)
+	}
+
+	token, err := parseTokenFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("http-client: token file %s: %w", s.path, err)
+	}
+
+	s.token, s.modTime, s.size = token, info.ModTime(), info.Size()
+	return token, nil
+}
+
+func (s *fileTokenSource) invalidate(r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.token = nil
+}
+
+func parseTokenFile(data []byte) (*oauth2.Token, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("empty token")
+	}
+
+	if data[0] != '{' {
+		return &oauth2.Token{AccessToken: string(data), TokenType: "Bearer"}, nil
+	}
+
+	token := new(oauth2.Token)
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, err
+	}
+
+	if token.AccessToken == "" {
+		return nil, errors.New("missing access_token")
+	}
+
+	return token, nil
+}
+
+// reuseTokenSource caches the token returned by new until it expires or is
+// invalidated after the resource server rejected it.
+type reuseTokenSource struct {
+	new oauth2.TokenSource
+
+	mu sync.Mutex // guards t
+	t  *oauth2.Token
+}
+
+func newReuseTokenSource(src oauth2.TokenSource) *reuseTokenSource {
+	return &reuseTokenSource{new: src}
+}
+
+// Token returns the cached token if it's still valid, else fetches a new one.
+func (s *reuseTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.t.Valid() {
+		return s.t, nil
+	}
+
+	t, err := s.new.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.t = t
+	return t, nil
+}
+
+// invalidate drops the cached token if it is still the one r was sent with.
+// Concurrent requests rejected with the same token only trigger a single
+// refresh.
+func (s *reuseTokenSource) invalidate(r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.t != nil && r.Header.Get(AuthorizationHeader) == s.t.Type()+" "+s.t.AccessToken {
+		s.t = nil
+	}
+}
+
+// tokenSourceFunc adapts an ordinary function to oauth2.TokenSource.
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+func (f tokenSourceFunc) Token() (*oauth2.Token, error) {
+	return f()
+}
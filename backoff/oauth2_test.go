@@ -0,0 +1,179 @@
+package backoff
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// oauth2Server is a token endpoint at /token and a resource at /resource.
+type oauth2Server struct {
+	*httptest.Server
+
+	tokens    atomic.Int32
+	resources atomic.Int32
+}
+
+// newOAuth2Server starts an oauth2Server. tokenStatus returns the status of
+// the n-th token request, 200 issuing token-n. resource answers requests to
+// the resource with the bearer token they were sent with.
+func newOAuth2Server(t *testing.T, tokenStatus func(n int) int, resource func(token string) int) *oauth2Server {
+	t.Helper()
+
+	s := &oauth2Server{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		n := int(s.tokens.Add(1))
+		w.Header().Set(ContentTypeHeader, ContentTypeJSON)
+		if status := tokenStatus(n); status != http.StatusOK {
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{"error":"invalid_client"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, n)
+	})
+	mux.HandleFunc("/resource", func(w http.ResponseWriter, r *http.Request) {
+		s.resources.Add(1)
+		w.WriteHeader(resource(strings.TrimPrefix(r.Header.Get(AuthorizationHeader), "Bearer ")))
+	})
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *oauth2Server) credentials() clientcredentials.Config {
+	return clientcredentials.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		TokenURL:     s.URL + "/token",
+	}
+}
+
+func TestErrorRetryPolicyRetrieveError(t *testing.T) {
+	tests := []struct {
+		name string
+		resp *http.Response
+		want bool
+	}{
+		{name: "no response"},
+		{name: "500", resp: &http.Response{StatusCode: http.StatusInternalServerError}, want: true},
+		{name: "503", resp: &http.Response{StatusCode: http.StatusServiceUnavailable}, want: true},
+		{name: "429", resp: &http.Response{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "400", resp: &http.Response{StatusCode: http.StatusBadRequest}},
+		{name: "401", resp: &http.Response{StatusCode: http.StatusUnauthorized}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fmt.Errorf("wrapped: %w", &oauth2.RetrieveError{Response: tt.resp})
+			if got := ErrorRetryPolicy(err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOAuth2TokenEndpointErrors(t *testing.T) {
+	// The token endpoint fails with 503, which is retried, then with 400,
+	// which is permanent.
+	server := newOAuth2Server(t, func(n int) int {
+		if n == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusBadRequest
+	}, func(string) int {
+		return http.StatusOK
+	})
+
+	c := NewBackoffClient(
+		WithClient(NewOAuth2Client(server.credentials())),
+		WithClock(&autoClock{now: time.Unix(0, 0)}),
+		WithBackoffStrategy(ConstantBackoff(time.Second)),
+		WithMaxRetry(5),
+	)
+
+	r, _ := http.NewRequest(http.MethodGet, server.URL+"/resource", nil)
+	_, err := c.Execute(r)
+
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		t.Fatalf("got error %v, want oauth2.RetrieveError", err)
+	}
+	if got := retrieveErr.Response.StatusCode; got != http.StatusBadRequest {
+		t.Errorf("got token endpoint status %d, want 400", got)
+	}
+
+	if got := server.tokens.Load(); got != 2 {
+		t.Errorf("got %d token requests, want 2", got)
+	}
+	if got := server.resources.Load(); got != 0 {
+		t.Errorf("got %d resource requests, want 0", got)
+	}
+}
+
+func TestOAuth2ClientRefreshesRevokedToken(t *testing.T) {
+	// token-1 was revoked before it expired.
+	server := newOAuth2Server(t, func(int) int {
+		return http.StatusOK
+	}, func(token string) int {
+		if token == "token-1" {
+			return http.StatusUnauthorized
+		}
+		return http.StatusOK
+	})
+
+	client := NewOAuth2Client(server.credentials())
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL + "/resource")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("request %d: got status %d, want 200", i, resp.StatusCode)
+		}
+	}
+
+	// The first request is retried once with token-2, which is then reused.
+	if got := server.resources.Load(); got != 3 {
+		t.Errorf("got %d resource requests, want 3", got)
+	}
+	if got := server.tokens.Load(); got != 2 {
+		t.Errorf("got %d token requests, want 2", got)
+	}
+}
+
+func TestOAuth2ClientRetriesUnauthorizedOnce(t *testing.T) {
+	server := newOAuth2Server(t, func(int) int {
+		return http.StatusOK
+	}, func(string) int {
+		return http.StatusUnauthorized
+	})
+
+	resp, err := NewOAuth2Client(server.credentials()).Get(server.URL + "/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got status %d, want 401", resp.StatusCode)
+	}
+	if got := server.resources.Load(); got != 2 {
+		t.Errorf("got %d resource requests, want 2", got)
+	}
+	if got := server.tokens.Load(); got != 2 {
+		t.Errorf("got %d token requests, want 2", got)
+	}
+}
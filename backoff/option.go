@@ -1,20 +1,38 @@
 package backoff
 
 import (
+	"math/rand/v2"
 	"net/http"
+	"slices"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 type (
 	RequestLogFunc  func(r *http.Request, err error, attempt int, next time.Duration)
 	ResponseLogFunc func(r *http.Request, w *http.Response, attempt int, duration time.Duration)
 	ErrorLogFunc    func(r *http.Request, err error, attempt int, duration time.Duration)
+
+	// ErrorRetryPolicyFunc reports whether a request that failed with err is retried.
+	ErrorRetryPolicyFunc func(err error) bool
+	// ResponseRetryPolicyFunc returns a non-nil error when the response is retried.
+	ResponseRetryPolicyFunc func(resp *http.Response) error
 )
 
 type config struct {
 	// Service name
 	service string
 
+	// baseURL is the URL relative request URLs are resolved against.
+	baseURL string
+
+	// headers are sent with every request unless the request sets them.
+	headers map[string]string
+
+	// userAgent is the default User-Agent, the service name if empty.
+	userAgent string
+
 	// max number of maxRetry
 	maxRetry uint64
 
@@ -27,12 +45,79 @@ type config struct {
 	// Exponential backoff multiplier.
 	multiplier float64
 
+	// strategy replaces the exponential backoff if set.
+	strategy Strategy
+
+	// clock tells the time and waits between retries.
+	clock Clock
+
+	// rand is the random source of the backoff jitter, the global one if nil.
+	rand *rand.Rand
+
 	// Request timeout.
 	timeout *time.Duration
 
+	// errorPolicy decides whether transport errors are retried.
+	errorPolicy ErrorRetryPolicyFunc
+
+	// responsePolicy decides whether responses are retried.
+	responsePolicy ResponseRetryPolicyFunc
+
+	// bodyPolicies decide whether responses are retried from their body,
+	// for bodies up to bodyPolicyLimit bytes.
+	bodyPolicies    []BodyRetryPolicyFunc
+	bodyPolicyLimit int64
+
+	// codecs encode request and decode response bodies.
+	codecs *CodecRegistry
+
+	// expectedStatus are the accepted status codes, any if empty.
+	expectedStatus map[int]struct{}
+
+	// validators check responses with an expected status code, and
+	// retryInvalid retries 2xx responses they reject.
+	validators   []ResponseValidatorFunc
+	retryInvalid bool
+
+	// requestEncoding compresses request bodies of at least requestMinSize bytes.
+	requestEncoding string
+	requestMinSize  int64
+
+	// decompress decodes compressed responses up to maxDecompressedSize bytes.
+	decompress          bool
+	maxDecompressedSize int64
+
 	// client Internal HTTP client.
 	client *http.Client
 
+	// auth authorizes every request sent by client.
+	auth AuthProvider
+
+	// signer signs every attempt.
+	signer Signer
+
+	// attemptHeader and requestIDHeader, if set, carry the attempt number
+	// and the request ID of every attempt.
+	attemptHeader   string
+	requestIDHeader string
+
+	// limiter limits the rate of attempts.
+	limiter *rate.Limiter
+
+	// routes select options by request, and route is the name of the
+	// route the request matched.
+	routes []routeRule
+	route  string
+
+	// cache stores responses to GET and HEAD requests.
+	cache CacheStore
+
+	// coalesce shares one in-flight GET or HEAD among identical concurrent
+	// requests without per-request options, told apart by method, URL,
+	// credentials and coalesceHeaders.
+	coalesce        bool
+	coalesceHeaders []string
+
 	// RequestLogHook allows a user-supplied function to be called before each retry.
 	RequestLogHook RequestLogFunc
 
@@ -54,13 +139,107 @@ func (o optionFunc) apply(c *config) {
 	o(c)
 }
 
-// WithClient sets the HTTP client in Config.
+// with returns a copy of the config with opts applied, leaving c untouched.
+func (c config) with(opts ...Option) config {
+	if len(opts) == 0 {
+		return c
+	}
+
+	headers := make(map[string]string, len(c.headers))
+	for key, value := range c.headers {
+		headers[key] = value
+	}
+	c.headers = headers
+
+	for _, opt := range opts {
+		opt.apply(&c)
+	}
+
+	return c
+}
+
+// WithClient sets the HTTP client requests are sent with in Config.
 func WithClient(client *http.Client) Option {
 	return optionFunc(func(c *config) {
 		c.client = client
 	})
 }
 
+// WithAuth sets the provider used to authorize requests in Config.
+func WithAuth(provider AuthProvider) Option {
+	return optionFunc(func(c *config) {
+		c.auth = provider
+	})
+}
+
+// WithSigner sets the request signer in Config. Every attempt is signed
+// after its body has been replayed.
+func WithSigner(signer Signer) Option {
+	return optionFunc(func(c *config) {
+		c.signer = signer
+	})
+}
+
+// WithAttemptHeaders sets the headers carrying the attempt number, from 1,
+// and the request ID shared by all attempts in Config, e.g.
+// WithAttemptHeaders(DefaultAttemptHeader, DefaultRequestIDHeader). An empty
+// name leaves that header out. A request ID the request already has is kept.
+func WithAttemptHeaders(attemptHeader, requestIDHeader string) Option {
+	return optionFunc(func(c *config) {
+		c.attemptHeader = http.CanonicalHeaderKey(attemptHeader)
+		c.requestIDHeader = http.CanonicalHeaderKey(requestIDHeader)
+	})
+}
+
+// WithRateLimit limits attempts, retries included, to limit per second with
+// bursts of up to burst in Config. Every call of WithRateLimit creates a new
+// limiter, shared by the requests it applies to, e.g. all requests of a route.
+func WithRateLimit(limit float64, burst int) Option {
+	limiter := rate.NewLimiter(rate.Limit(limit), burst)
+	return optionFunc(func(c *config) {
+		c.limiter = limiter
+	})
+}
+
+// WithRoute applies opts to the requests matching route in Config, e.g.
+//
+//	WithRoute(Route{Name: "payments", Host: "pay.example.com", Methods: []string{http.MethodPost}},
+//		WithTimeout(2*time.Second),
+//		WithMaxRetry(1),
+//	)
+//
+// Only the first matching route applies, in the order routes were added.
+// Route options override the client options and are overridden by per-request
+// options. Options configuring the underlying http.Client, like WithClient and
+// WithAuth, and the base URL have no effect in a route.
+func WithRoute(route Route, opts ...Option) Option {
+	return optionFunc(func(c *config) {
+		c.routes = append(slices.Clip(c.routes), routeRule{route: route, opts: opts})
+	})
+}
+
+// WithCache caches responses to GET and HEAD requests in store in Config,
+// following Cache-Control, Expires, ETag and Last-Modified as a private cache.
+func WithCache(store CacheStore) Option {
+	return optionFunc(func(c *config) {
+		c.cache = store
+	})
+}
+
+// WithRequestCoalescing makes concurrent identical GET and HEAD requests
+// share a single Execute, retries included, in Config. Requests are identical
+// when their method, URL, Authorization and Cookie headers and the values of
+// the given headers match. Requests executed with per-request options are
+// never coalesced. Every caller gets its own copy of the response and stops
+// waiting when its context is done, the shared request is cancelled once no
+// caller is left.
+func WithRequestCoalescing(headers ...string) Option {
+	return optionFunc(func(c *config) {
+		c.coalesce = true
+		c.coalesceHeaders = headers
+	})
+}
+
 // WithService sets the service name in Config.
 func WithService(service string) Option {
 	return optionFunc(func(cfg *config) {
@@ -68,6 +247,36 @@ func WithService(service string) Option {
 	})
 }
 
+// WithBaseURL sets the URL that relative request URLs are resolved against in Config.
+func WithBaseURL(baseURL string) Option {
+	return optionFunc(func(c *config) {
+		c.baseURL = baseURL
+	})
+}
+
+// WithHeader sets a default header sent with every request in Config.
+func WithHeader(key, value string) Option {
+	return optionFunc(func(c *config) {
+		c.headers[http.CanonicalHeaderKey(key)] = value
+	})
+}
+
+// WithHeaders sets default headers sent with every request in Config.
+func WithHeaders(headers map[string]string) Option {
+	return optionFunc(func(c *config) {
+		for key, value := range headers {
+			c.headers[http.CanonicalHeaderKey(key)] = value
+		}
+	})
+}
+
+// WithUserAgent sets the default User-Agent in Config, the service name is used otherwise.
+func WithUserAgent(userAgent string) Option {
+	return optionFunc(func(c *config) {
+		c.userAgent = userAgent
+	})
+}
+
 // WithTimeout sets the request timeout in Config.
 func WithTimeout(timeout time.Duration) Option {
 	return optionFunc(func(cfg *config) {
@@ -96,12 +305,133 @@ func WithMaxInterval(max time.Duration) Option {
 	})
 }
 
+// WithCodecs sets the codec registry used for request and response bodies in Config.
+func WithCodecs(codecs *CodecRegistry) Option {
+	return optionFunc(func(c *config) {
+		c.codecs = codecs
+	})
+}
+
+// WithErrorRetryPolicy sets the policy deciding which transport errors are retried in Config.
+func WithErrorRetryPolicy(policy ErrorRetryPolicyFunc) Option {
+	return optionFunc(func(c *config) {
+		c.errorPolicy = policy
+	})
+}
+
+// WithResponseRetryPolicy sets the policy deciding which responses are retried in Config.
+func WithResponseRetryPolicy(policy ResponseRetryPolicyFunc) Option {
+	return optionFunc(func(c *config) {
+		c.responsePolicy = policy
+	})
+}
+
+// WithBodyRetryPolicy adds body retry policies in Config. They run in order
+// on the read, and decompressed, body of every response that passed the
+// response retry policy and has an expected status code. Bodies larger than DefaultBodyRetryPolicyLimit are
+// not inspected, see WithBodyRetryPolicyLimit.
+func WithBodyRetryPolicy(policies ...BodyRetryPolicyFunc) Option {
+	return optionFunc(func(c *config) {
+		// Clip so that per-request policies never write to the client's slice.
+		c.bodyPolicies = append(slices.Clip(c.bodyPolicies), policies...)
+	})
+}
+
+// WithBodyRetryPolicyLimit sets the size up to which bodies are inspected by
+// body retry policies in Config.
+func WithBodyRetryPolicyLimit(limit int64) Option {
+	return optionFunc(func(c *config) {
+		c.bodyPolicyLimit = limit
+	})
+}
+
+// WithExpectedStatus sets the accepted status codes in Config. Any other
+// status code that is not retried fails the request with a StatusError.
+func WithExpectedStatus(codes ...int) Option {
+	return optionFunc(func(c *config) {
+		c.expectedStatus = make(map[int]struct{}, len(codes))
+		for _, code := range codes {
+			c.expectedStatus[code] = struct{}{}
+		}
+	})
+}
+
+// WithResponseValidator adds response validators in Config. They run in
+// order on responses with an expected status code, and the first failure
+// fails the request with a permanent ValidationError.
+func WithResponseValidator(validators ...ResponseValidatorFunc) Option {
+	return optionFunc(func(c *config) {
+		// Clip so that per-request validators never write to the client's slice.
+		c.validators = append(slices.Clip(c.validators), validators...)
+	})
+}
+
+// WithRetryOnInvalidResponse retries 2xx responses rejected by a response
+// validator instead of failing right away in Config.
+func WithRetryOnInvalidResponse() Option {
+	return optionFunc(func(c *config) {
+		c.retryInvalid = true
+	})
+}
+
+// WithRequestCompression compresses request bodies of at least minSize bytes
+// with encoding, EncodingGzip or EncodingZstd, in Config. Bodies of unknown
+// length, like multipart streams, are sent uncompressed.
+func WithRequestCompression(encoding string, minSize int64) Option {
+	return optionFunc(func(c *config) {
+		c.requestEncoding = encoding
+		c.requestMinSize = minSize
+	})
+}
+
+// WithResponseDecompression decodes gzip, deflate, br and zstd response
+// bodies in Config. Accept-Encoding is sent unless the request sets it. A
+// body that decompresses to more than maxSize bytes fails with ErrBodyTooLarge,
+// DefaultMaxDecompressedSize is used if maxSize is not positive.
+func WithResponseDecompression(maxSize int64) Option {
+	return optionFunc(func(c *config) {
+		if maxSize <= 0 {
+			maxSize = DefaultMaxDecompressedSize
+		}
+		c.decompress = true
+		c.maxDecompressedSize = maxSize
+	})
+}
+
+// WithMultiplier sets the exponential backoff multiplier in Config.
 func WithMultiplier(multiplier float64) Option {
 	return optionFunc(func(c *config) {
 		c.multiplier = multiplier
 	})
 }
 
+// WithBackoffStrategy sets the backoff strategy in Config, replacing the
+// exponential backoff, e.g. WithBackoffStrategy(FullJitterBackoff(100*time.Millisecond, 10*time.Second)).
+// WithMaxRetry still limits the number of retries, which stop after
+// DefaultMaxElapsedTime in any case.
+func WithBackoffStrategy(strategy Strategy) Option {
+	return optionFunc(func(c *config) {
+		c.strategy = strategy
+	})
+}
+
+// WithClock sets the clock used to wait between retries, measure attempts
+// and age cached responses in Config.
+func WithClock(clock Clock) Option {
+	return optionFunc(func(c *config) {
+		c.clock = clock
+	})
+}
+
+// WithRandSource sets the random source of the backoff jitter in Config,
+// e.g. WithRandSource(rand.NewPCG(1, 2)) for a reproducible wait schedule.
+// Strategies given as BackoffFunc are not affected.
+func WithRandSource(src rand.Source) Option {
+	return optionFunc(func(c *config) {
+		c.rand = rand.New(&lockedSource{src: src})
+	})
+}
+
 // WithRequestLogHook sets the request log hook in Config.
 func WithRequestLogHook(hook RequestLogFunc) Option {
 	return optionFunc(func(c *config) {
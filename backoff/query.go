@@ -0,0 +1,224 @@
+package backoff
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArrayFormat defines how query parameters with multiple values are encoded.
+type ArrayFormat int
+
+const (
+	// ArrayFormatRepeat repeats the key for every value: ids=1&ids=2.
+	ArrayFormatRepeat ArrayFormat = iota
+	// ArrayFormatComma joins the values with commas: ids=1,2.
+	ArrayFormatComma
+	// ArrayFormatBrackets repeats the key with brackets appended: ids[]=1&ids[]=2.
+	ArrayFormatBrackets
+)
+
+// encodeQuery encodes the values sorted by key like url.Values.Encode, using
+// format for keys with multiple values and for the keys in arrays, whatever
+// their number of values.
+func encodeQuery(values url.Values, arrays map[string]bool, format ArrayFormat) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	write := func(key, value string) {
+		if b.Len() > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(value)
+	}
+
+	for _, key := range keys {
+		vs := values[key]
+		escapedKey := url.QueryEscape(key)
+
+		switch {
+		case format == ArrayFormatRepeat || len(vs) < 2 && !arrays[key]:
+			for _, v := range vs {
+				write(escapedKey, url.QueryEscape(v))
+			}
+		case format == ArrayFormatComma:
+			escaped := make([]string, len(vs))
+			for i, v := range vs {
+				escaped[i] = url.QueryEscape(v)
+			}
+			write(escapedKey, strings.Join(escaped, ","))
+		case format == ArrayFormatBrackets:
+			for _, v := range vs {
+				write(url.QueryEscape(key+"[]"), url.QueryEscape(v))
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// EncodeQueryStruct encodes the exported fields of a struct, or a pointer to
+// one, as query parameters. The parameter name is taken from the `url` tag,
+// or the field name if there is none, e.g.
+//
+//	type Filter struct {
+//		Name  string    `url:"name,omitempty"`
+//		IDs   []int     `url:"id"`
+//		Since time.Time `url:"since,omitempty"`
+//		Debug bool      `url:"-"`
+//	}
+//
+// Slices and arrays produce one value per element. Pointers are followed and
+// left out when nil. time.Time is formatted as RFC 3339. Fields of embedded
+// structs are promoted.
+func EncodeQueryStruct(v any) (url.Values, error) {
+	return encodeQueryStruct(v, nil)
+}
+
+// encodeQueryStruct is EncodeQueryStruct, adding the names of slice and
+// array fields to arrays if not nil.
+func encodeQueryStruct(v any, arrays map[string]bool) (url.Values, error) {
+	values := url.Values{}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return values, nil
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("http-client: query struct: expected a struct, got %s", rv.Kind())
+	}
+
+	if err := encodeStructFields(values, arrays, rv); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+func encodeStructFields(values url.Values, arrays map[string]bool, rv reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("url")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		omitEmpty := slices.Contains(strings.Split(opts, ","), "omitempty")
+
+		fv := rv.Field(i)
+		if field.Anonymous && name == "" {
+			for fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					break
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct && fv.Type() != reflect.TypeOf(time.Time{}) {
+				if err := encodeStructFields(values, arrays, fv); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		if omitEmpty && fv.IsZero() {
+			continue
+		}
+
+		if err := encodeQueryValue(values, arrays, name, fv); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func encodeQueryValue(values url.Values, arrays map[string]bool, name string, fv reflect.Value) error {
+	for fv.Kind() == reflect.Pointer || fv.Kind() == reflect.Interface {
+		if fv.IsNil() {
+			return nil
+		}
+		fv = fv.Elem()
+	}
+
+	if fv.Kind() == reflect.Slice || fv.Kind() == reflect.Array {
+		if arrays != nil {
+			arrays[name] = true
+		}
+		for i := 0; i < fv.Len(); i++ {
+			s, err := formatQueryScalar(fv.Index(i))
+			if err != nil {
+				return fmt.Errorf("http-client: query struct field %s: %w", name, err)
+			}
+			values.Add(name, s)
+		}
+		return nil
+	}
+
+	s, err := formatQueryScalar(fv)
+	if err != nil {
+		return fmt.Errorf("http-client: query struct field %s: %w", name, err)
+	}
+
+	values.Add(name, s)
+	return nil
+}
+
+func formatQueryScalar(fv reflect.Value) (string, error) {
+	for fv.Kind() == reflect.Pointer || fv.Kind() == reflect.Interface {
+		if fv.IsNil() {
+			return "", nil
+		}
+		fv = fv.Elem()
+	}
+
+	if t, ok := fv.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339), nil
+	}
+
+	if stringer, ok := fv.Interface().(fmt.Stringer); ok {
+		return stringer.String(), nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fv.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(fv.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'f', -1, 64), nil
+	}
+
+	return "", fmt.Errorf("unsupported type %s", fv.Type())
+}
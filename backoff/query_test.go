@@ -0,0 +1,156 @@
+package backoff
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestEncodeQueryArrayFormats(t *testing.T) {
+	type query struct {
+		IDs   []int  `url:"ids"`
+		Page  int    `url:"page"`
+		Empty []int  `url:"empty"`
+		Name  string `url:"name,omitempty"`
+	}
+
+	tests := []struct {
+		name   string
+		format ArrayFormat
+		v      query
+		want   string
+	}{
+		{name: "repeat", format: ArrayFormatRepeat, v: query{IDs: []int{1, 2}, Page: 3}, want: "ids=1&ids=2&page=3"},
+		{name: "comma", format: ArrayFormatComma, v: query{IDs: []int{1, 2}, Page: 3}, want: "ids=1,2&page=3"},
+		{name: "brackets", format: ArrayFormatBrackets, v: query{IDs: []int{1, 2}, Page: 3}, want: "ids%5B%5D=1&ids%5B%5D=2&page=3"},
+		{name: "repeat single", format: ArrayFormatRepeat, v: query{IDs: []int{1}}, want: "ids=1&page=0"},
+		{name: "comma single", format: ArrayFormatComma, v: query{IDs: []int{1}}, want: "ids=1&page=0"},
+		{name: "brackets single", format: ArrayFormatBrackets, v: query{IDs: []int{1}}, want: "ids%5B%5D=1&page=0"},
+		{name: "repeat empty", format: ArrayFormatRepeat, v: query{IDs: []int{}}, want: "page=0"},
+		{name: "comma empty", format: ArrayFormatComma, v: query{}, want: "page=0"},
+		{name: "brackets empty", format: ArrayFormatBrackets, v: query{}, want: "page=0"},
+		{name: "escaped values", format: ArrayFormatComma, v: query{IDs: []int{1, 2}, Name: "a,b c"}, want: "ids=1,2&name=a%2Cb+c&page=0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arrays := make(map[string]bool)
+			values, err := encodeQueryStruct(tt.v, arrays)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := encodeQuery(values, arrays, tt.format); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeQueryMultipleValues(t *testing.T) {
+	values := url.Values{"tag": {"a", "b"}, "q": {"x"}}
+
+	for format, want := range map[ArrayFormat]string{
+		ArrayFormatRepeat:   "q=x&tag=a&tag=b",
+		ArrayFormatComma:    "q=x&tag=a,b",
+		ArrayFormatBrackets: "q=x&tag%5B%5D=a&tag%5B%5D=b",
+	} {
+		if got := encodeQuery(values, nil, format); got != want {
+			t.Errorf("format %d: got %q, want %q", format, got, want)
+		}
+	}
+}
+
+// Sorting is embedded by pointer, it must be exported to be promoted.
+type Sorting struct {
+	Sort string `url:"sort,omitempty"`
+}
+
+func TestEncodeQueryStruct(t *testing.T) {
+	type Paging struct {
+		Page  int `url:"page,omitempty"`
+		Limit int `url:"limit"`
+	}
+
+	type Filter struct {
+		Paging
+		*Sorting
+		Name    string     `url:"name,omitempty,other"`
+		Tags    []string   `url:"tag,omitempty"`
+		Since   time.Time  `url:"since,omitempty"`
+		Until   *time.Time `url:"until"`
+		Active  *bool      `url:"active"`
+		Score   float64    `url:"score"`
+		Ignored string     `url:"-"`
+		Untag   uint8
+		hidden  string
+	}
+
+	since := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	active := true
+
+	tests := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{
+			name: "zero values",
+			v:    Filter{},
+			want: "Untag=0&limit=0&score=0",
+		},
+		{
+			name: "all fields",
+			v: &Filter{
+				Paging:  Paging{Page: 2, Limit: 10},
+				Sorting: &Sorting{Sort: "name"},
+				Name:    "x",
+				Tags:    []string{"a", "b"},
+				Since:   since,
+				Until:   &since,
+				Active:  &active,
+				Score:   1.5,
+				Ignored: "ignored",
+				Untag:   7,
+				hidden:  "hidden",
+			},
+			want: "Untag=7&active=true&limit=10&name=x&page=2&score=1.5&since=2024-05-01T12%3A00%3A00Z&sort=name&tag=a&tag=b&until=2024-05-01T12%3A00%3A00Z",
+		},
+		{
+			name: "nil pointer",
+			v:    (*Filter)(nil),
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := EncodeQueryStruct(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := values.Encode(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, v := range []any{42, "query", struct{ C chan int }{make(chan int)}} {
+		if _, err := EncodeQueryStruct(v); err == nil {
+			t.Errorf("EncodeQueryStruct(%T): got no error", v)
+		}
+	}
+}
+
+func TestRequestBuilderQueryStructArrayFormat(t *testing.T) {
+	r := build(t, NewRequestBuilder().
+		URL("https://example.com?q=x").
+		QueryStruct(struct {
+			IDs []int `url:"ids"`
+		}{IDs: []int{1}}).
+		QueryParam("page", "2").
+		QueryArrayFormat(ArrayFormatBrackets))
+
+	if got, want := r.URL.RawQuery, "ids%5B%5D=1&page=2&q=x"; got != want {
+		t.Errorf("got query %q, want %q", got, want)
+	}
+}
@@ -3,7 +3,8 @@ package backoff
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -30,22 +31,59 @@ var (
 	UserAgentHeader = http.CanonicalHeaderKey("User-Agent")
 	// ContentTypeHeader is the key for the Content-Type header.
 	ContentTypeHeader = http.CanonicalHeaderKey("Content-Type")
+	// AcceptHeader is the key for the Accept header.
+	AcceptHeader = http.CanonicalHeaderKey("Accept")
+	// AuthorizationHeader is the key for the Authorization header.
+	AuthorizationHeader = http.CanonicalHeaderKey("Authorization")
+)
+
+var (
+	// ErrInvalidMethod is returned by Build when the request method is not a valid HTTP token.
+	ErrInvalidMethod = errors.New("http-client: invalid method")
+	// ErrInvalidURL is returned by Build when the request URL is missing or not an absolute http(s) URL.
+	ErrInvalidURL = errors.New("http-client: invalid url")
+	// ErrBodyNotAllowed is returned by Build when a body is set for a method that doesn't allow one.
+	ErrBodyNotAllowed = errors.New("http-client: body not allowed")
 )
 
 type RequestBuilder struct {
 	method  string
+	baseURL string
 	url     string
+	params  map[string]string
 	query   url.Values
-	headers map[string]string
+	format  ArrayFormat
+
+	// arrays are the query keys set from slices, encoded with format even
+	// when they have a single value.
+	arrays map[string]bool
+
+	headers http.Header
 	form    url.Values
 	body    io.Reader
+
+	// multipart is the multipart/form-data body, if any.
+	multipart *multipartBody
+
+	// value is encoded into the body by Build, using codecs.
+	value  *encodedBody
+	codecs *CodecRegistry
+
+	// errs collects the errors of the builder methods, returned by Build.
+	errs []error
+
+	// client sends the request on Do, opts override its configuration.
+	client *BackoffClient
+	opts   []Option
 }
 
 // Constructor to create a new Request instance
 func NewRequestBuilder() *RequestBuilder {
 	return &RequestBuilder{
 		query:   url.Values{},
-		headers: make(map[string]string),
+		headers: make(http.Header),
+		params:  make(map[string]string),
+		form:    url.Values{},
 	}
 }
 
@@ -61,118 +99,402 @@ func (rb *RequestBuilder) URL(url string) *RequestBuilder {
 	return rb
 }
 
+// BaseURL sets the URL that relative request URLs are resolved against
+func (rb *RequestBuilder) BaseURL(baseURL string) *RequestBuilder {
+	rb.baseURL = baseURL
+	return rb
+}
+
+// PathParam sets the value of a {key} placeholder in the URL path, the value is path escaped
+func (rb *RequestBuilder) PathParam(key, value string) *RequestBuilder {
+	rb.params[key] = value
+	return rb
+}
+
+// PathParams sets the values of multiple {key} placeholders in the URL path
+func (rb *RequestBuilder) PathParams(params map[string]string) *RequestBuilder {
+	for key, value := range params {
+		rb.params[key] = value
+	}
+	return rb
+}
+
 // QueryParam adds a query parameter to the URL
 func (rb *RequestBuilder) QueryParam(key, value string) *RequestBuilder {
 	rb.query.Add(key, value)
 	return rb
 }
 
-// Query adds multiple query parameters to the URL
+// Query adds multiple query parameters to the URL, merging values of existing keys
 func (rb *RequestBuilder) Query(query url.Values) *RequestBuilder {
-	if len(query) == 0 {
-		return rb
+	for key, values := range query {
+		rb.query[key] = append(rb.query[key], values...)
 	}
+	return rb
+}
 
-	if len(rb.query) == 0 {
-		rb.query = query
-		return rb
+// QueryStruct adds the fields of a struct as query parameters, see EncodeQueryStruct
+func (rb *RequestBuilder) QueryStruct(v any) *RequestBuilder {
+	if rb.arrays == nil {
+		rb.arrays = make(map[string]bool)
 	}
 
-	for key, value := range query {
-		rb.query[key] = value
+	query, err := encodeQueryStruct(v, rb.arrays)
+	if err != nil {
+		rb.errs = append(rb.errs, err)
+		return rb
 	}
+	return rb.Query(query)
+}
+
+// QueryArrayFormat sets how query parameters with multiple values, or set
+// from slice fields by QueryStruct, are encoded
+func (rb *RequestBuilder) QueryArrayFormat(format ArrayFormat) *RequestBuilder {
+	rb.format = format
 	return rb
 }
 
-// Header to set a header
+// Header to set a header, replacing existing values
 func (rb *RequestBuilder) Header(key, value string) *RequestBuilder {
-	rb.headers[key] = value
+	return rb.SetHeader(key, value)
+}
+
+// SetHeader sets a header, replacing existing values
+func (rb *RequestBuilder) SetHeader(key, value string) *RequestBuilder {
+	rb.headers.Set(key, value)
+	return rb
+}
+
+// AddHeader adds a value to a header, keeping existing values
+func (rb *RequestBuilder) AddHeader(key, value string) *RequestBuilder {
+	rb.headers.Add(key, value)
+	return rb
+}
+
+// DelHeader removes all values of a header
+func (rb *RequestBuilder) DelHeader(key string) *RequestBuilder {
+	rb.headers.Del(key)
 	return rb
 }
 
 // Headers to set multiple headers
 func (rb *RequestBuilder) Headers(headers map[string]string) *RequestBuilder {
 	for key, value := range headers {
-		rb.headers[key] = value
+		rb.headers.Set(key, value)
+	}
+	return rb
+}
+
+// HeaderValues adds multiple headers with all of their values
+func (rb *RequestBuilder) HeaderValues(headers http.Header) *RequestBuilder {
+	for key, values := range headers {
+		for _, value := range values {
+			rb.headers.Add(key, value)
+		}
 	}
 	return rb
 }
 
 // ContentType sets the Content-Type header
 func (rb *RequestBuilder) ContentType(contentType string) *RequestBuilder {
-	rb.headers[ContentTypeHeader] = contentType
+	rb.headers.Set(ContentTypeHeader, contentType)
 	return rb
 }
 
 // UserAgent sets the User-Agent header
 func (rb *RequestBuilder) UserAgent(userAgent string) *RequestBuilder {
-	rb.headers[UserAgentHeader] = userAgent
+	rb.headers.Set(UserAgentHeader, userAgent)
+	return rb
+}
+
+// Accept sets the Accept header to the given media types
+func (rb *RequestBuilder) Accept(mediaTypes ...string) *RequestBuilder {
+	rb.headers.Set(AcceptHeader, strings.Join(mediaTypes, ", "))
+	return rb
+}
+
+// Codecs sets the registry used to encode the body, DefaultCodecs by default
+func (rb *RequestBuilder) Codecs(codecs *CodecRegistry) *RequestBuilder {
+	rb.codecs = codecs
 	return rb
 }
 
 // Body sets the body of the request
 func (rb *RequestBuilder) Body(body io.Reader) *RequestBuilder {
-	rb.body = body
+	rb.body, rb.value = body, nil
 	return rb
 }
 
 // BodyString sets the body from a string
 func (rb *RequestBuilder) BodyString(body string) *RequestBuilder {
-	rb.body = strings.NewReader(body)
-	return rb
+	return rb.Body(strings.NewReader(body))
 }
 
 // BodyBytes sets the body from a byte slice
 func (rb *RequestBuilder) BodyBytes(body []byte) *RequestBuilder {
-	rb.body = bytes.NewReader(body)
-	return rb
+	return rb.Body(bytes.NewReader(body))
 }
 
-// BodyJSON sets the body from a JSON object
+// BodyJSON sets the body from a JSON object, encoded by the JSON codec
 func (rb *RequestBuilder) BodyJSON(data any) *RequestBuilder {
-	buffer, err := json.Marshal(data)
-	if err != nil {
-		return rb
-	}
-	rb.body = bytes.NewBuffer(buffer)
+	rb.body, rb.value = nil, &encodedBody{value: data, codecType: ContentTypeJSON}
+	return rb
+}
+
+// BodyEncoded sets the body from a value encoded by the codec registered
+// for the Content-Type header, JSON if the header is not set
+func (rb *RequestBuilder) BodyEncoded(data any) *RequestBuilder {
+	rb.body, rb.value = nil, &encodedBody{value: data}
 	return rb
 }
 
 // PostForm sets the form data for a POST request (url.Values)
 func (rb *RequestBuilder) PostForm(form map[string]string) *RequestBuilder {
+	if rb.form == nil {
+		rb.form = url.Values{}
+	}
+
 	for key, value := range form {
 		rb.form.Add(key, value)
 	}
 	return rb
 }
 
+// Options overrides the client configuration for this request only, e.g.
+// WithTimeout, WithMaxRetry, WithResponseRetryPolicy or WithExpectedStatus.
+// Options configuring the underlying http.Client, like WithClient and
+// WithAuth, and the base URL have no effect per request.
+func (rb *RequestBuilder) Options(opts ...Option) *RequestBuilder {
+	rb.opts = append(rb.opts, opts...)
+	return rb
+}
+
+// ExpectStatus accepts only the given status codes for this request, see
+// WithExpectedStatus.
+func (rb *RequestBuilder) ExpectStatus(codes ...int) *RequestBuilder {
+	return rb.Options(WithExpectedStatus(codes...))
+}
+
+// ValidateResponse adds response validators for this request, see
+// WithResponseValidator.
+func (rb *RequestBuilder) ValidateResponse(validators ...ResponseValidatorFunc) *RequestBuilder {
+	return rb.Options(WithResponseValidator(validators...))
+}
+
+// Do builds the request and executes it with the client the builder was
+// obtained from, see BackoffClient.R.
+func (rb *RequestBuilder) Do(ctx context.Context) (*Response, error) {
+	if rb.client == nil {
+		return nil, errors.New("http-client: request builder has no client, use BackoffClient.R")
+	}
+
+	r, err := rb.Build(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return rb.client.Execute(r, rb.opts...)
+}
+
+// Validate checks the method, the URL and whether the method allows a body.
+func (rb *RequestBuilder) Validate() error {
+	var errs []error
+
+	if rb.method != "" && !validMethod(rb.method) {
+		errs = append(errs, fmt.Errorf("%w: %q", ErrInvalidMethod, rb.method))
+	}
+
+	if _, err := rb.requestURL(); err != nil {
+		errs = append(errs, err)
+	}
+
+	bodies := 0
+	for _, set := range []bool{rb.body != nil && rb.body != http.NoBody || rb.value != nil, len(rb.form) > 0, rb.multipart != nil} {
+		if set {
+			bodies++
+		}
+	}
+
+	if bodies > 1 {
+		errs = append(errs, fmt.Errorf("%w: only one of body, form data and multipart can be set", ErrBodyNotAllowed))
+	}
+
+	if bodies > 0 {
+		switch rb.method {
+		case http.MethodHead, http.MethodTrace, http.MethodConnect:
+			errs = append(errs, fmt.Errorf("%w: %s request", ErrBodyNotAllowed, rb.method))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
 // Method to build and return the final *http.Request
 func (rb *RequestBuilder) Build(ctx context.Context) (*http.Request, error) {
-	u, err := url.Parse(rb.url)
+	if err := errors.Join(rb.errs...); err != nil {
+		return nil, err
+	}
+
+	if err := rb.Validate(); err != nil {
+		return nil, err
+	}
+
+	u, err := rb.requestURL()
 	if err != nil {
 		return nil, err
 	}
 
-	// Add query parameters to the URL if any
-	u.RawQuery = rb.query.Encode()
+	// Add query parameters to the URL if any, keeping those already in the URL
+	if len(rb.query) > 0 {
+		query := u.Query()
+		for key, values := range rb.query {
+			query[key] = append(query[key], values...)
+		}
+		u.RawQuery = encodeQuery(query, rb.arrays, rb.format)
+	}
+
+	method, body := rb.method, rb.body
+	contentType := ""
+
+	// Encode the body value with the codec for its media type
+	if rb.value != nil {
+		data, mediaType, err := rb.encodeValue()
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+		if rb.headers.Get(ContentTypeHeader) == "" {
+			contentType = mediaType
+		}
+	}
 
-	// Encode form data if present
+	// Encode form data if present, sent with POST unless another method is set
 	if len(rb.form) > 0 {
-		rb.method = http.MethodPost
-		rb.headers[ContentTypeHeader] = ContentTypeForm
-		rb.body = strings.NewReader(rb.form.Encode())
+		if method == "" {
+			method = http.MethodPost
+		}
+		contentType = ContentTypeForm
+		body = strings.NewReader(rb.form.Encode())
+	}
+
+	if rb.multipart != nil {
+		if method == "" {
+			method = http.MethodPost
+		}
+		contentType = rb.multipart.contentType()
+		stream, err := rb.multipart.open()
+		if err != nil {
+			return nil, err
+		}
+		body = stream
 	}
 
 	// Create the request
-	r, err := http.NewRequestWithContext(ctx, rb.method, u.String(), rb.body)
+	r, err := http.NewRequestWithContext(ctx, method, u.String(), body)
 	if err != nil {
 		return nil, err
 	}
 
+	// The multipart stream is recreated for every attempt
+	if rb.multipart != nil {
+		r.GetBody = rb.multipart.open
+	}
+
 	// Add headers to the request
-	for key, value := range rb.headers {
-		r.Header.Set(key, value)
+	for key, values := range rb.headers {
+		r.Header[key] = append([]string(nil), values...)
+	}
+
+	// Form encodings always win over a user supplied Content-Type
+	if contentType != "" {
+		r.Header.Set(ContentTypeHeader, contentType)
 	}
 
 	return r, nil
 }
+
+// encodedBody is a body value encoded by a codec when the request is built.
+type encodedBody struct {
+	value any
+
+	// codecType selects the codec, the Content-Type header if empty.
+	codecType string
+}
+
+// encodeValue encodes the body value and returns its media type.
+func (rb *RequestBuilder) encodeValue() ([]byte, string, error) {
+	codecs := rb.codecs
+	if codecs == nil {
+		codecs = DefaultCodecs
+	}
+
+	mediaType := rb.value.codecType
+	if mediaType == "" {
+		mediaType = rb.headers.Get(ContentTypeHeader)
+	}
+	if mediaType == "" {
+		mediaType = ContentTypeJSON
+	}
+
+	data, err := codecs.marshal(mediaType, rb.value.value)
+	if err != nil {
+		return nil, "", fmt.Errorf("http-client: failed to encode %s body: %w", mediaType, err)
+	}
+
+	return data, mediaType, nil
+}
+
+// validMethod reports whether method is a valid HTTP token (RFC 9110).
+func validMethod(method string) bool {
+	return strings.IndexFunc(method, func(r rune) bool {
+		return r > '~' || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r)
+	}) == -1
+}
+
+// requestURL expands the path parameters, resolves the URL against the base
+// URL if any, and checks that the result is an absolute http or https URL.
+func (rb *RequestBuilder) requestURL() (*url.URL, error) {
+	if rb.url == "" && rb.baseURL == "" {
+		return nil, fmt.Errorf("%w: empty url", ErrInvalidURL)
+	}
+
+	// Path parameters are only replaced in the path, the query and fragment
+	// are left alone.
+	path, rest := rb.url, ""
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path, rest = path[:i], path[i:]
+	}
+
+	for key, value := range rb.params {
+		path = strings.ReplaceAll(path, "{"+key+"}", url.PathEscape(value))
+	}
+
+	if start := strings.IndexByte(path, '{'); start >= 0 {
+		if end := strings.IndexByte(path[start:], '}'); end > 0 {
+			return nil, fmt.Errorf("%w: missing path parameter %s", ErrInvalidURL, path[start:start+end+1])
+		}
+	}
+
+	u, err := url.Parse(path + rest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidURL, err)
+	}
+
+	if rb.baseURL != "" {
+		base, err := url.Parse(rb.baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: base url: %w", ErrInvalidURL, err)
+		}
+		u = base.ResolveReference(u)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrInvalidURL, u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w: missing host in %q", ErrInvalidURL, u)
+	}
+
+	return u, nil
+}
@@ -0,0 +1,351 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// build builds rb and fails the test on error.
+func build(t *testing.T, rb *RequestBuilder) *http.Request {
+	t.Helper()
+
+	r, err := rb.Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func readBody(t *testing.T, r *http.Request) string {
+	t.Helper()
+
+	if r.Body == nil {
+		return ""
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRequestBuilderSetters(t *testing.T) {
+	r := build(t, NewRequestBuilder().
+		Method(http.MethodPut).
+		BaseURL("https://api.example.com/v1/").
+		URL("users/{id}/posts/{slug}?sort=asc").
+		PathParam("id", "42").
+		PathParams(map[string]string{"slug": "hello world"}).
+		QueryParam("tag", "a").
+		Query(url.Values{"tag": {"b"}, "page": {"2"}}).
+		QueryStruct(struct {
+			Limit int `url:"limit"`
+		}{Limit: 10}).
+		QueryArrayFormat(ArrayFormatComma).
+		Header("X-One", "1").
+		SetHeader("X-Two", "2").
+		AddHeader("X-Two", "3").
+		AddHeader("X-Gone", "x").
+		DelHeader("X-Gone").
+		Headers(map[string]string{"X-Three": "3"}).
+		HeaderValues(http.Header{"X-Four": {"4", "5"}}).
+		ContentType(ContentTypeText).
+		UserAgent("builder-test").
+		Accept(ContentTypeJSON, ContentTypeXML).
+		BodyString("payload"))
+
+	if r.Method != http.MethodPut {
+		t.Errorf("got method %q, want PUT", r.Method)
+	}
+
+	wantURL := "https://api.example.com/v1/users/42/posts/hello%20world?limit=10&page=2&sort=asc&tag=a,b"
+	if got := r.URL.String(); got != wantURL {
+		t.Errorf("got URL %q, want %q", got, wantURL)
+	}
+
+	for key, want := range map[string][]string{
+		"X-One":           {"1"},
+		"X-Two":           {"2", "3"},
+		"X-Three":         {"3"},
+		"X-Four":          {"4", "5"},
+		"X-Gone":          nil,
+		ContentTypeHeader: {ContentTypeText},
+		UserAgentHeader:   {"builder-test"},
+		AcceptHeader:      {"application/json, application/xml"},
+	} {
+		if got := r.Header.Values(key); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("got %s %q, want %q", key, got, want)
+		}
+	}
+
+	if got := readBody(t, r); got != "payload" {
+		t.Errorf("got body %q, want %q", got, "payload")
+	}
+}
+
+func TestRequestBuilderPathParamsOnlyInPath(t *testing.T) {
+	tests := []struct {
+		name string
+		rb   *RequestBuilder
+		want string
+	}{
+		{
+			name: "braces in query",
+			rb:   NewRequestBuilder().URL(`https://example.com/users/{id}?filter={"a":1}`).PathParam("id", "42"),
+			want: `https://example.com/users/42?filter={"a":1}`,
+		},
+		{
+			name: "parameter name in query",
+			rb:   NewRequestBuilder().URL("https://example.com/users/{id}?q={id}#{id}").PathParam("id", "a b"),
+			want: "https://example.com/users/a%20b?q={id}#%7Bid%7D",
+		},
+		{
+			name: "braces in query with added parameters",
+			rb:   NewRequestBuilder().URL(`https://example.com/search?filter={"a":1}`).QueryParam("page", "2"),
+			want: "https://example.com/search?filter=%7B%22a%22%3A1%7D&page=2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := build(t, tt.rb).URL.String(); got != tt.want {
+				t.Errorf("got URL %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestBuilderBodies(t *testing.T) {
+	tests := []struct {
+		name        string
+		rb          *RequestBuilder
+		body        string
+		contentType string
+	}{
+		{
+			name: "bytes",
+			rb:   NewRequestBuilder().Method(http.MethodPost).URL("https://example.com").BodyBytes([]byte("raw")),
+			body: "raw",
+		},
+		{
+			name: "reader",
+			rb:   NewRequestBuilder().Method(http.MethodPost).URL("https://example.com").Body(strings.NewReader("stream")),
+			body: "stream",
+		},
+		{
+			name:        "json",
+			rb:          NewRequestBuilder().Method(http.MethodPost).URL("https://example.com").BodyJSON(map[string]int{"a": 1}),
+			body:        `{"a":1}`,
+			contentType: ContentTypeJSON,
+		},
+		{
+			name:        "json keeps content type",
+			rb:          NewRequestBuilder().Method(http.MethodPost).URL("https://example.com").ContentType("application/vnd.api+json").BodyJSON(map[string]int{"a": 1}),
+			body:        `{"a":1}`,
+			contentType: "application/vnd.api+json",
+		},
+		{
+			name:        "encoded defaults to json",
+			rb:          NewRequestBuilder().Method(http.MethodPost).URL("https://example.com").BodyEncoded([]int{1, 2}),
+			body:        `[1,2]`,
+			contentType: ContentTypeJSON,
+		},
+		{
+			name: "later body wins",
+			rb:   NewRequestBuilder().Method(http.MethodPost).URL("https://example.com").BodyJSON(1).BodyString("text"),
+			body: "text",
+		},
+		{
+			name:        "form defaults to POST",
+			rb:          NewRequestBuilder().URL("https://example.com").PostForm(map[string]string{"a": "1", "b": "x y"}),
+			body:        "a=1&b=x+y",
+			contentType: ContentTypeForm,
+		},
+		{
+			name:        "form overrides content type",
+			rb:          NewRequestBuilder().Method(http.MethodPut).URL("https://example.com").ContentType(ContentTypeJSON).PostForm(map[string]string{"a": "1"}),
+			body:        "a=1",
+			contentType: ContentTypeForm,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := build(t, tt.rb)
+			if got := readBody(t, r); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+			if got := r.Header.Get(ContentTypeHeader); got != tt.contentType {
+				t.Errorf("got Content-Type %q, want %q", got, tt.contentType)
+			}
+			if r.GetBody == nil {
+				t.Error("got no GetBody, the body cannot be replayed")
+			}
+		})
+	}
+}
+
+func TestRequestBuilderPostFormOnFreshBuilder(t *testing.T) {
+	// A zero RequestBuilder has no form map yet.
+	rb := &RequestBuilder{headers: make(http.Header)}
+	r := build(t, rb.URL("https://example.com").PostForm(map[string]string{"a": "1"}))
+
+	if r.Method != http.MethodPost {
+		t.Errorf("got method %q, want POST", r.Method)
+	}
+	if got := readBody(t, r); got != "a=1" {
+		t.Errorf("got body %q, want %q", got, "a=1")
+	}
+}
+
+func TestRequestBuilderBuildErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rb   *RequestBuilder
+		want error
+	}{
+		{
+			name: "json marshal error",
+			rb:   NewRequestBuilder().Method(http.MethodPost).URL("https://example.com").BodyJSON(make(chan int)),
+		},
+		{
+			name: "query struct error",
+			rb:   NewRequestBuilder().URL("https://example.com").QueryStruct(42),
+		},
+		{
+			name: "invalid method",
+			rb:   NewRequestBuilder().Method("GET /").URL("https://example.com"),
+			want: ErrInvalidMethod,
+		},
+		{
+			name: "empty url",
+			rb:   NewRequestBuilder(),
+			want: ErrInvalidURL,
+		},
+		{
+			name: "relative url",
+			rb:   NewRequestBuilder().URL("/users"),
+			want: ErrInvalidURL,
+		},
+		{
+			name: "unsupported scheme",
+			rb:   NewRequestBuilder().URL("ftp://example.com"),
+			want: ErrInvalidURL,
+		},
+		{
+			name: "missing host",
+			rb:   NewRequestBuilder().URL("https:///users"),
+			want: ErrInvalidURL,
+		},
+		{
+			name: "missing path parameter",
+			rb:   NewRequestBuilder().URL("https://example.com/users/{id}"),
+			want: ErrInvalidURL,
+		},
+		{
+			name: "invalid base url",
+			rb:   NewRequestBuilder().BaseURL("https://exa mple.com").URL("/users"),
+			want: ErrInvalidURL,
+		},
+		{
+			name: "body and form",
+			rb:   NewRequestBuilder().Method(http.MethodPost).URL("https://example.com").BodyString("x").PostForm(map[string]string{"a": "1"}),
+			want: ErrBodyNotAllowed,
+		},
+		{
+			name: "body with HEAD",
+			rb:   NewRequestBuilder().Method(http.MethodHead).URL("https://example.com").BodyString("x"),
+			want: ErrBodyNotAllowed,
+		},
+		{
+			name: "form with TRACE",
+			rb:   NewRequestBuilder().Method(http.MethodTrace).URL("https://example.com").PostForm(map[string]string{"a": "1"}),
+			want: ErrBodyNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.rb.Build(context.Background())
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestBuilderValidate(t *testing.T) {
+	valid := NewRequestBuilder().Method(http.MethodPost).URL("https://example.com").BodyString("x")
+	if err := valid.Validate(); err != nil {
+		t.Errorf("valid request: got error %v", err)
+	}
+
+	// Every problem is reported at once.
+	err := NewRequestBuilder().Method("BAD METHOD").URL("ftp://example.com").BodyString("x").PostForm(map[string]string{"a": "1"}).Validate()
+	for _, want := range []error{ErrInvalidMethod, ErrInvalidURL, ErrBodyNotAllowed} {
+		if !errors.Is(err, want) {
+			t.Errorf("got error %v, want it to wrap %v", err, want)
+		}
+	}
+}
+
+func TestRequestBuilderDo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	if _, err := NewRequestBuilder().URL(server.URL).Do(context.Background()); err == nil {
+		t.Error("builder without client: got no error")
+	}
+
+	c := NewBackoffClient(WithMaxRetry(1))
+
+	resp, err := c.R().URL(server.URL).Do(context.Background())
+	if err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Errorf("got %v, %v, want 202", resp, err)
+	}
+
+	var statusErr *StatusError
+	if _, err := c.R().URL(server.URL).ExpectStatus(http.StatusOK).Do(context.Background()); !errors.As(err, &statusErr) {
+		t.Errorf("with expected status: got error %v, want StatusError", err)
+	}
+}
+
+func TestBackoffClientDoRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Test", r.Header.Get("X-Test"))
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	c := NewBackoffClient(WithMaxRetry(1))
+
+	resp, err := c.DoRequest(context.Background(), "PURGE", server.URL, strings.NewReader("body"), map[string]string{"X-Test": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("X-Method") != "PURGE" || resp.Header.Get("X-Test") != "a" || string(resp.Body) != "body" {
+		t.Errorf("got header %v and body %q, want PURGE, X-Test a and body", resp.Header, resp.Body)
+	}
+
+	// Do is the one of the embedded http.Client.
+	r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	httpResp, err := c.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp.Body.Close()
+}
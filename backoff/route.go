@@ -0,0 +1,93 @@
+package backoff
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Route selects the requests a set of options applies to, see WithRoute.
+// Empty fields match any request.
+type Route struct {
+	// Name identifies the route in AttemptInfo and MatchRoute.
+	Name string
+
+	// Host matches the request host, with or without its port. A leading
+	// "*." matches any subdomain, e.g. "*.example.com".
+	Host string
+
+	// PathPrefix matches request paths starting with it.
+	PathPrefix string
+
+	// PathPattern matches request paths against a regular expression.
+	PathPattern *regexp.Regexp
+
+	// Methods match the request method.
+	Methods []string
+}
+
+type routeRule struct {
+	route Route
+	opts  []Option
+}
+
+// MatchRoute returns the name of the route the request matches, if any.
+func (c *BackoffClient) MatchRoute(r *http.Request) (string, bool) {
+	rule := c.cfg.matchRoute(r)
+	if rule == nil {
+		return "", false
+	}
+	return rule.route.Name, true
+}
+
+// matchRoute returns the first route rule matching r.
+func (c *config) matchRoute(r *http.Request) *routeRule {
+	for i := range c.routes {
+		if c.routes[i].route.matches(r) {
+			return &c.routes[i]
+		}
+	}
+	return nil
+}
+
+func (route Route) matches(r *http.Request) bool {
+	if route.Host != "" && !matchHost(route.Host, r.URL.Host) {
+		return false
+	}
+
+	if route.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, route.PathPrefix) {
+		return false
+	}
+
+	if route.PathPattern != nil && !route.PathPattern.MatchString(r.URL.Path) {
+		return false
+	}
+
+	if len(route.Methods) > 0 && !slices.ContainsFunc(route.Methods, func(method string) bool {
+		return strings.EqualFold(method, r.Method)
+	}) {
+		return false
+	}
+
+	return true
+}
+
+func matchHost(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+	if pattern == host {
+		return true
+	}
+
+	// Patterns without a port match any port.
+	if hostname, _, err := net.SplitHostPort(host); err == nil && !strings.Contains(pattern, ":") {
+		host = hostname
+	}
+
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+
+	return pattern == host
+}
@@ -0,0 +1,130 @@
+package backoff
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestMatchRoute(t *testing.T) {
+	c := NewBackoffClient(
+		WithRoute(Route{Name: "orders-write", Host: "api.example.com", PathPrefix: "/orders", Methods: []string{http.MethodPost, "put"}}),
+		WithRoute(Route{Name: "orders", Host: "api.example.com", PathPrefix: "/orders"}),
+		WithRoute(Route{Name: "search", PathPattern: regexp.MustCompile(`^/search/\w+$`)}),
+		WithRoute(Route{Name: "internal", Host: "*.internal"}),
+		WithRoute(Route{Name: "admin", Host: "admin.example.com:8443"}),
+		WithRoute(Route{Name: "unreachable", Host: "api.example.com"}),
+	)
+
+	tests := []struct {
+		method string
+		url    string
+		want   string
+	}{
+		{method: http.MethodPost, url: "https://api.example.com/orders/1", want: "orders-write"},
+		{method: http.MethodPut, url: "https://api.example.com/orders/1", want: "orders-write"},
+		{method: http.MethodGet, url: "https://api.example.com/orders/1", want: "orders"},
+		{method: http.MethodGet, url: "https://API.example.com:8443/orders", want: "orders"},
+		{method: http.MethodGet, url: "https://api.example.com/users", want: "unreachable"},
+		{method: http.MethodGet, url: "https://other.example.com/orders"},
+		{method: http.MethodGet, url: "https://other.example.com/search/abc", want: "search"},
+		{method: http.MethodGet, url: "https://other.example.com/search/a/b"},
+		{method: http.MethodGet, url: "http://billing.svc.internal:8080/", want: "internal"},
+		{method: http.MethodGet, url: "http://internal/"},
+		{method: http.MethodGet, url: "https://admin.example.com:8443/", want: "admin"},
+		{method: http.MethodGet, url: "https://admin.example.com/"},
+	}
+
+	for _, tt := range tests {
+		r, _ := http.NewRequest(tt.method, tt.url, nil)
+		got, ok := c.MatchRoute(r)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("%s %s: got route %q, %v, want %q", tt.method, tt.url, got, ok, tt.want)
+		}
+	}
+}
+
+func TestRouteOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	transport := &recordingTransport{}
+	c := NewBackoffClient(
+		WithClient(&http.Client{Transport: transport}),
+		WithClock(&autoClock{now: time.Unix(0, 0)}),
+		WithBackoffStrategy(ConstantBackoff(time.Second)),
+		WithMaxRetry(1),
+		WithRoute(Route{Name: "flaky", PathPrefix: "/flaky"}, WithMaxRetry(3)),
+	)
+
+	tests := []struct {
+		path     string
+		opts     []Option
+		attempts int
+		route    string
+	}{
+		{path: "/flaky", attempts: 4, route: "flaky"},
+		{path: "/stable", attempts: 2},
+		{path: "/flaky", opts: []Option{WithMaxRetry(2)}, attempts: 3, route: "flaky"},
+	}
+
+	for _, tt := range tests {
+		transport.attempts = nil
+
+		r, _ := http.NewRequest(http.MethodGet, server.URL+tt.path, nil)
+		if _, err := c.Execute(r, tt.opts...); err == nil {
+			t.Errorf("%s: got no error", tt.path)
+		}
+
+		if len(transport.attempts) != tt.attempts {
+			t.Errorf("%s: got %d attempts, want %d", tt.path, len(transport.attempts), tt.attempts)
+		}
+		for _, info := range transport.attempts {
+			if info.Route != tt.route {
+				t.Errorf("%s: got route %q in the attempt, want %q", tt.path, info.Route, tt.route)
+			}
+		}
+	}
+}
+
+func TestRouteRateLimit(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	// One request, then one every 1000s: the second request to the route
+	// would outlive its context.
+	c := NewBackoffClient(WithRoute(Route{PathPrefix: "/limited"}, WithRateLimit(0.001, 1)))
+
+	get := func(path string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		r, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+		_, err := c.Execute(r)
+		return err
+	}
+
+	if err := get("/limited/1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := get("/limited/2"); err == nil {
+		t.Error("got no error for a rate limited request")
+	}
+	for i := 0; i < 3; i++ {
+		if err := get("/other"); err != nil {
+			t.Errorf("request %d outside the route: got error %v", i, err)
+		}
+	}
+
+	if requests != 4 {
+		t.Errorf("got %d requests, want 4", requests)
+	}
+}
@@ -0,0 +1,370 @@
+package backoff
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrStreamingBody is returned by signers that need the request body when it
+// is streamed, like multipart bodies, and cannot be read without being consumed.
+var ErrStreamingBody = errors.New("http-client: cannot sign a streamed request body")
+
+// Signer signs outgoing requests. It is called for every attempt, after the
+// body has been replayed, so timestamps and content hashes are always fresh.
+// Streamed bodies, like multipart bodies, must not be read by signers.
+type Signer interface {
+	Sign(r *http.Request) error
+}
+
+// SignerFunc adapts an ordinary function to Signer.
+type SignerFunc func(r *http.Request) error
+
+func (f SignerFunc) Sign(r *http.Request) error {
+	return f(r)
+}
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	sigV4DateFormat = "20060102"
+
+	// sigV4UnsignedPayload replaces the payload hash of streamed bodies.
+	sigV4UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// SigV4Signer signs requests with AWS Signature Version 4. Streamed bodies,
+// like multipart uploads, are not buffered and are signed as UNSIGNED-PAYLOAD,
+// which S3 accepts but most other services don't.
+type SigV4Signer struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+	Service         string
+
+	// Clock tells the signing time, SystemClock if nil.
+	Clock Clock
+}
+
+// NewSigV4Signer returns a SigV4Signer for the given credentials, region and service.
+func NewSigV4Signer(accessKeyID, secretAccessKey, region, service string) *SigV4Signer {
+	return &SigV4Signer{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		Region:          region,
+		Service:         service,
+	}
+}
+
+// Sign sets the X-Amz-Date and Authorization headers on r. S3 requests also
+// carry the X-Amz-Content-Sha256 header.
+func (s *SigV4Signer) Sign(r *http.Request) error {
+	payloadHash := sigV4UnsignedPayload
+	body, err := requestBody(r)
+	switch {
+	case errors.Is(err, ErrStreamingBody):
+		// Streamed bodies are sent unsigned instead of being buffered.
+	case err != nil:
+		return err
+	default:
+		payloadHash = sha256Hex(body)
+	}
+
+	t := signingTime(s.Clock)
+
+	r.Header.Set("X-Amz-Date", t.Format(sigV4TimeFormat))
+	if s.Service == "s3" {
+		r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	if s.SessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers, signedHeaders := sigV4CanonicalHeaders(r)
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		sigV4CanonicalURI(r.URL, s.Service != "s3"),
+		sigV4CanonicalQuery(r.URL),
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{t.Format(sigV4DateFormat), s.Region, s.Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		t.Format(sigV4TimeFormat),
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), t.Format(sigV4DateFormat))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	r.Header.Set(AuthorizationHeader, sigV4Algorithm+
+		" Credential="+s.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+signature)
+	return nil
+}
+
+// sigV4CanonicalURI returns the URI-encoded path. Every segment is decoded
+// and encoded once for S3, every other service expects it encoded twice.
+func sigV4CanonicalURI(u *url.URL, doubleEncode bool) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if decoded, err := url.PathUnescape(segment); err == nil {
+			segment = decoded
+		}
+
+		segments[i] = sigV4Escape(segment)
+		if doubleEncode {
+			segments[i] = sigV4Escape(segments[i])
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// sigV4CanonicalQuery returns the query parameters encoded and sorted by
+// encoded name, then by encoded value.
+func sigV4CanonicalQuery(u *url.URL) string {
+	type param struct{ key, value string }
+
+	var params []param
+	for key, values := range u.Query() {
+		for _, value := range values {
+			params = append(params, param{sigV4Escape(key), sigV4Escape(value)})
+		}
+	}
+
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].key != params[j].key {
+			return params[i].key < params[j].key
+		}
+		return params[i].value < params[j].value
+	})
+
+	pairs := make([]string, len(params))
+	for i, p := range params {
+		pairs[i] = p.key + "=" + p.value
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sigV4CanonicalHeaders returns the canonical headers block and the signed
+// headers list. The host, content-type and x-amz-* headers are signed.
+func sigV4CanonicalHeaders(r *http.Request) (string, string) {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	values := map[string]string{"host": host}
+	for key, vs := range r.Header {
+		name := strings.ToLower(key)
+		if name != "content-type" && !strings.HasPrefix(name, "x-amz-") {
+			continue
+		}
+
+		trimmed := make([]string, len(vs))
+		for i, v := range vs {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		values[name] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + values[name] + "\n")
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+// sigV4Escape percent-encodes everything except RFC 3986 unreserved characters.
+func sigV4Escape(s string) string {
+	const hexDigits = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&0x0f])
+	}
+	return b.String()
+}
+
+const (
+	DefaultSignatureHeader = "X-Signature"
+	DefaultTimestampHeader = "X-Timestamp"
+	DefaultKeyIDHeader     = "X-Key-Id"
+)
+
+// HMACSigner signs requests with a shared secret. By default the string to
+// sign is the method, the request URI, the unix timestamp and the hex SHA-256
+// of the body, joined by newlines, and the base64 signature is sent in the
+// X-Signature header along with X-Timestamp and X-Key-Id. Streamed bodies,
+// like multipart uploads, cannot be signed, Sign fails with ErrStreamingBody.
+type HMACSigner struct {
+	KeyID  string
+	Secret []byte
+
+	// Hash is the hash function used for the HMAC, SHA-256 if nil.
+	Hash func() hash.Hash
+
+	// SignatureHeader, TimestampHeader and KeyIDHeader override the default
+	// header names. KeyIDHeader is only sent when KeyID is set.
+	SignatureHeader string
+	TimestampHeader string
+	KeyIDHeader     string
+
+	// StringToSign overrides the default canonical form of the request.
+	StringToSign func(r *http.Request, timestamp time.Time, bodyHash string) string
+
+	// Clock tells the signing time, SystemClock if nil.
+	Clock Clock
+}
+
+// NewHMACSigner returns an HMACSigner with the default settings.
+func NewHMACSigner(keyID string, secret []byte) *HMACSigner {
+	return &HMACSigner{
+		KeyID:  keyID,
+		Secret: secret,
+	}
+}
+
+// Sign sets the signature, timestamp and key id headers on r.
+func (s *HMACSigner) Sign(r *http.Request) error {
+	body, err := requestBody(r)
+	if err != nil {
+		return err
+	}
+
+	t := signingTime(s.Clock)
+
+	stringToSign := hmacStringToSign
+	if s.StringToSign != nil {
+		stringToSign = s.StringToSign
+	}
+
+	newHash := s.Hash
+	if newHash == nil {
+		newHash = sha256.New
+	}
+
+	mac := hmac.New(newHash, s.Secret)
+	mac.Write([]byte(stringToSign(r, t, sha256Hex(body))))
+
+	r.Header.Set(headerOrDefault(s.SignatureHeader, DefaultSignatureHeader), base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	r.Header.Set(headerOrDefault(s.TimestampHeader, DefaultTimestampHeader), strconv.FormatInt(t.Unix(), 10))
+	if s.KeyID != "" {
+		r.Header.Set(headerOrDefault(s.KeyIDHeader, DefaultKeyIDHeader), s.KeyID)
+	}
+	return nil
+}
+
+func hmacStringToSign(r *http.Request, timestamp time.Time, bodyHash string) string {
+	return strings.Join([]string{
+		r.Method,
+		r.URL.RequestURI(),
+		strconv.FormatInt(timestamp.Unix(), 10),
+		bodyHash,
+	}, "\n")
+}
+
+// signingTime returns the current time of clock in UTC.
+func signingTime(clock Clock) time.Time {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return clock.Now().UTC()
+}
+
+func headerOrDefault(header, fallback string) string {
+	if header == "" {
+		return fallback
+	}
+	return header
+}
+
+// streamingBody is implemented by request bodies that are generated while
+// being sent and never buffered.
+type streamingBody interface {
+	streaming()
+}
+
+// requestBody returns the request body without consuming it. A body that
+// cannot be replayed through GetBody is buffered and put back on r. Streamed
+// bodies are left alone and ErrStreamingBody is returned.
+func requestBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if _, ok := r.Body.(streamingBody); ok {
+		return nil, ErrStreamingBody
+	}
+
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+
+	data, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return data, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
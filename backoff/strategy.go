@@ -0,0 +1,230 @@
+package backoff
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// Strategy creates the backoff used by a single Execute call. The default
+// is cenkalti's exponential backoff configured by WithInitialInterval,
+// WithMaxInterval and WithMultiplier. Like the default, every strategy stops
+// retrying once DefaultMaxElapsedTime has elapsed.
+type Strategy interface {
+	NewBackOff() backoff.BackOff
+}
+
+// BackoffFunc is a Strategy returning the wait before the given retry,
+// counted from 1. Returning backoff.Stop ends the retries.
+type BackoffFunc func(attempt int) time.Duration
+
+func (f BackoffFunc) NewBackOff() backoff.BackOff {
+	return &attemptBackOff{next: f}
+}
+
+// attemptBackOff adapts a BackoffFunc to backoff.BackOff.
+type attemptBackOff struct {
+	next    BackoffFunc
+	attempt int
+}
+
+func (b *attemptBackOff) NextBackOff() time.Duration {
+	b.attempt++
+	return b.next(b.attempt)
+}
+
+func (b *attemptBackOff) Reset() { b.attempt = 0 }
+
+// ConstantBackoff waits interval before every retry.
+func ConstantBackoff(interval time.Duration) Strategy {
+	return BackoffFunc(func(int) time.Duration {
+		return interval
+	})
+}
+
+// LinearBackoff waits initial before the first retry and step longer before
+// each following one, up to max.
+func LinearBackoff(initial, step, max time.Duration) Strategy {
+	return BackoffFunc(func(attempt int) time.Duration {
+		return capDuration(float64(initial)+float64(step)*float64(attempt-1), max)
+	})
+}
+
+// FibonacciBackoff waits base times the Fibonacci sequence, 1, 1, 2, 3, 5,
+// and so on, up to max.
+func FibonacciBackoff(base, max time.Duration) Strategy {
+	return BackoffFunc(func(attempt int) time.Duration {
+		prev, cur := 0.0, 1.0
+		for i := 1; i < attempt && float64(base)*cur < float64(max); i++ {
+			prev, cur = cur, prev+cur
+		}
+		return capDuration(float64(base)*cur, max)
+	})
+}
+
+// FullJitterBackoff waits a random duration between zero and the
+// exponential backoff base*2^(attempt-1), capped at max.
+func FullJitterBackoff(base, max time.Duration) Strategy {
+	return jitterFunc(func(rnd *rand.Rand, attempt int) time.Duration {
+		return randDuration(rnd, 0, exponential(base, max, attempt))
+	})
+}
+
+// EqualJitterBackoff waits half of the exponential backoff
+// base*2^(attempt-1), capped at max, plus a random duration up to the other half.
+func EqualJitterBackoff(base, max time.Duration) Strategy {
+	return jitterFunc(func(rnd *rand.Rand, attempt int) time.Duration {
+		half := exponential(base, max, attempt) / 2
+		return half + randDuration(rnd, 0, half)
+	})
+}
+
+// randomizedStrategy is implemented by strategies drawing random numbers, so
+// that WithRandSource can make them deterministic.
+type randomizedStrategy interface {
+	newBackOff(rnd *rand.Rand) backoff.BackOff
+}
+
+// jitterFunc is a BackoffFunc drawing from a random source.
+type jitterFunc func(rnd *rand.Rand, attempt int) time.Duration
+
+func (f jitterFunc) NewBackOff() backoff.BackOff {
+	return f.newBackOff(globalRand)
+}
+
+func (f jitterFunc) newBackOff(rnd *rand.Rand) backoff.BackOff {
+	return &attemptBackOff{next: func(attempt int) time.Duration {
+		return f(rnd, attempt)
+	}}
+}
+
+// exponentialJitter is cenkalti's exponential backoff drawing from a given
+// random source, used in its place when WithRandSource is set.
+type exponentialJitter struct {
+	cfg *config
+	rnd *rand.Rand
+
+	interval time.Duration
+	start    time.Time
+}
+
+func newExponentialJitter(cfg *config, rnd *rand.Rand) *exponentialJitter {
+	b := &exponentialJitter{cfg: cfg, rnd: rnd}
+	b.Reset()
+	return b
+}
+
+func (b *exponentialJitter) NextBackOff() time.Duration {
+	if b.cfg.clock.Now().Sub(b.start) > DefaultMaxElapsedTime {
+		return backoff.Stop
+	}
+
+	interval := b.interval
+	b.interval = capDuration(float64(b.interval)*b.cfg.multiplier, b.cfg.maxInterval)
+
+	delta := time.Duration(backoff.DefaultRandomizationFactor * float64(interval))
+	return randDuration(b.rnd, interval-delta, interval+delta)
+}
+
+func (b *exponentialJitter) Reset() {
+	b.interval = b.cfg.initialInterval
+	b.start = b.cfg.clock.Now()
+}
+
+// maxElapsedBackOff stops a backoff once DefaultMaxElapsedTime has elapsed
+// since it was created or reset.
+type maxElapsedBackOff struct {
+	backoff.BackOff
+	clock Clock
+	start time.Time
+}
+
+func newMaxElapsedBackOff(b backoff.BackOff, clock Clock) *maxElapsedBackOff {
+	return &maxElapsedBackOff{BackOff: b, clock: clock, start: clock.Now()}
+}
+
+func (b *maxElapsedBackOff) NextBackOff() time.Duration {
+	if b.clock.Now().Sub(b.start) > DefaultMaxElapsedTime {
+		return backoff.Stop
+	}
+	return b.BackOff.NextBackOff()
+}
+
+func (b *maxElapsedBackOff) Reset() {
+	b.BackOff.Reset()
+	b.start = b.clock.Now()
+}
+
+// DecorrelatedJitterBackoff waits a random duration between base and three
+// times the previous wait, capped at max.
+func DecorrelatedJitterBackoff(base, max time.Duration) Strategy {
+	return decorrelatedJitter{base: base, max: max}
+}
+
+type decorrelatedJitter struct {
+	base, max time.Duration
+}
+
+func (d decorrelatedJitter) NewBackOff() backoff.BackOff {
+	return d.newBackOff(globalRand)
+}
+
+func (d decorrelatedJitter) newBackOff(rnd *rand.Rand) backoff.BackOff {
+	return &decorrelatedJitterBackOff{decorrelatedJitter: d, rnd: rnd, prev: d.base}
+}
+
+type decorrelatedJitterBackOff struct {
+	decorrelatedJitter
+	rnd  *rand.Rand
+	prev time.Duration
+}
+
+func (b *decorrelatedJitterBackOff) NextBackOff() time.Duration {
+	b.prev = min(b.max, randDuration(b.rnd, b.base, capDuration(float64(b.prev)*3, b.max)))
+	return b.prev
+}
+
+func (b *decorrelatedJitterBackOff) Reset() { b.prev = b.base }
+
+// exponential returns base*2^(attempt-1) capped at max.
+func exponential(base, max time.Duration, attempt int) time.Duration {
+	return capDuration(float64(base)*math.Pow(2, float64(attempt-1)), max)
+}
+
+// capDuration converts d to a duration no longer than max, avoiding overflows.
+func capDuration(d float64, max time.Duration) time.Duration {
+	if d >= float64(max) {
+		return max
+	}
+	return time.Duration(d)
+}
+
+// randDuration returns a random duration in [lo, hi].
+func randDuration(rnd *rand.Rand, lo, hi time.Duration) time.Duration {
+	if hi <= lo {
+		return lo
+	}
+	return lo + time.Duration(rnd.Int64N(int64(hi-lo)+1))
+}
+
+// globalRand draws from the global source of math/rand/v2.
+var globalRand = rand.New(globalSource{})
+
+type globalSource struct{}
+
+func (globalSource) Uint64() uint64 { return rand.Uint64() }
+
+// lockedSource makes a rand.Source safe for concurrent use.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
@@ -0,0 +1,275 @@
+package backoff
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// autoClock is a Clock whose waits return at once, advancing its time.
+type autoClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *autoClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *autoClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func waits(b backoff.BackOff, n int) []time.Duration {
+	waits := make([]time.Duration, n)
+	for i := range waits {
+		waits[i] = b.NextBackOff()
+	}
+	return waits
+}
+
+func TestDeterministicStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy Strategy
+		want     []time.Duration
+	}{
+		{
+			name:     "constant",
+			strategy: ConstantBackoff(time.Second),
+			want:     []time.Duration{time.Second, time.Second, time.Second},
+		},
+		{
+			name:     "linear",
+			strategy: LinearBackoff(time.Second, 2*time.Second, 6*time.Second),
+			want:     []time.Duration{time.Second, 3 * time.Second, 5 * time.Second, 6 * time.Second},
+		},
+		{
+			name:     "fibonacci",
+			strategy: FibonacciBackoff(time.Second, 6*time.Second),
+			want:     []time.Duration{time.Second, time.Second, 2 * time.Second, 3 * time.Second, 5 * time.Second, 6 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.strategy.NewBackOff()
+			for i, want := range tt.want {
+				if got := b.NextBackOff(); got != want {
+					t.Errorf("retry %d: got %v, want %v", i+1, got, want)
+				}
+			}
+
+			b.Reset()
+			if got := b.NextBackOff(); got != tt.want[0] {
+				t.Errorf("after reset: got %v, want %v", got, tt.want[0])
+			}
+		})
+	}
+}
+
+// sampleStats draws n backoffs from newBackOff at the given retry and
+// returns their minimum, maximum and mean.
+func sampleStats(n, retry int, newBackOff func(rnd *rand.Rand) backoff.BackOff) (lo, hi, mean time.Duration) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	lo = time.Duration(math.MaxInt64)
+
+	var sum float64
+	for i := 0; i < n; i++ {
+		b := newBackOff(rnd)
+		var d time.Duration
+		for j := 0; j < retry; j++ {
+			d = b.NextBackOff()
+		}
+		lo, hi = min(lo, d), max(hi, d)
+		sum += float64(d)
+	}
+
+	return lo, hi, time.Duration(sum / float64(n))
+}
+
+func TestJitterDistributions(t *testing.T) {
+	const (
+		samples = 20000
+		base    = 100 * time.Millisecond
+		maxWait = 10 * time.Second
+	)
+
+	cfg := NewBackoffClient().cfg
+
+	tests := []struct {
+		name       string
+		retry      int
+		newBackOff func(rnd *rand.Rand) backoff.BackOff
+		lo, hi     time.Duration
+		mean       time.Duration
+	}{
+		{
+			// Uniform on [0, base*2^2].
+			name:       "full jitter",
+			retry:      3,
+			newBackOff: FullJitterBackoff(base, maxWait).(randomizedStrategy).newBackOff,
+			lo:         0,
+			hi:         4 * base,
+			mean:       2 * base,
+		},
+		{
+			name:       "full jitter capped",
+			retry:      20,
+			newBackOff: FullJitterBackoff(base, maxWait).(randomizedStrategy).newBackOff,
+			lo:         0,
+			hi:         maxWait,
+			mean:       maxWait / 2,
+		},
+		{
+			// Half of base*2^2 plus a uniform draw on the other half.
+			name:       "equal jitter",
+			retry:      3,
+			newBackOff: EqualJitterBackoff(base, maxWait).(randomizedStrategy).newBackOff,
+			lo:         2 * base,
+			hi:         4 * base,
+			mean:       3 * base,
+		},
+		{
+			// Uniform on [base, 3*base].
+			name:       "decorrelated jitter",
+			retry:      1,
+			newBackOff: DecorrelatedJitterBackoff(base, maxWait).(randomizedStrategy).newBackOff,
+			lo:         base,
+			hi:         3 * base,
+			mean:       2 * base,
+		},
+		{
+			// Uniform within the randomization factor around the interval.
+			name:  "exponential",
+			retry: 1,
+			newBackOff: func(rnd *rand.Rand) backoff.BackOff {
+				return newExponentialJitter(&cfg, rnd)
+			},
+			lo:   cfg.initialInterval / 2,
+			hi:   cfg.initialInterval * 3 / 2,
+			mean: cfg.initialInterval,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lo, hi, mean := sampleStats(samples, tt.retry, tt.newBackOff)
+
+			if lo < tt.lo || hi > tt.hi {
+				t.Errorf("got waits in [%v, %v], want within [%v, %v]", lo, hi, tt.lo, tt.hi)
+			}
+
+			// The draws should also cover the range, not only stay within it.
+			if spread := tt.hi - tt.lo; lo > tt.lo+spread/20 || hi < tt.hi-spread/20 {
+				t.Errorf("got waits in [%v, %v], want them to cover [%v, %v]", lo, hi, tt.lo, tt.hi)
+			}
+
+			// The standard error of the mean of a uniform distribution is
+			// spread/sqrt(12n), allow for five of them.
+			tolerance := time.Duration(5 * float64(tt.hi-tt.lo) / math.Sqrt(12*samples))
+			if diff := mean - tt.mean; diff < -tolerance || diff > tolerance {
+				t.Errorf("got mean %v, want %v ± %v", mean, tt.mean, tolerance)
+			}
+		})
+	}
+}
+
+func TestDecorrelatedJitterBounds(t *testing.T) {
+	const base, maxWait = 100 * time.Millisecond, 5 * time.Second
+
+	b := DecorrelatedJitterBackoff(base, maxWait).(randomizedStrategy).newBackOff(rand.New(rand.NewPCG(3, 4)))
+	prev := base
+	for i := 0; i < 1000; i++ {
+		d := b.NextBackOff()
+		if d < base || d > maxWait || d > 3*prev {
+			t.Fatalf("retry %d: got %v after %v, want within [%v, min(%v, %v)]", i+1, d, prev, base, 3*prev, maxWait)
+		}
+		prev = d
+	}
+}
+
+func TestRandSourceIsDeterministic(t *testing.T) {
+	for _, strategy := range []Strategy{
+		FullJitterBackoff(time.Millisecond, time.Second),
+		EqualJitterBackoff(time.Millisecond, time.Second),
+		DecorrelatedJitterBackoff(time.Millisecond, time.Second),
+		nil,
+	} {
+		c1 := NewBackoffClient(WithBackoffStrategy(strategy), WithRandSource(rand.NewPCG(1, 2)))
+		c2 := NewBackoffClient(WithBackoffStrategy(strategy), WithRandSource(rand.NewPCG(1, 2)))
+
+		got1 := waits(newBackOff(context.Background(), &c1.cfg), 10)
+		got2 := waits(newBackOff(context.Background(), &c2.cfg), 10)
+		for i := range got1 {
+			if got1[i] != got2[i] {
+				t.Errorf("%T: retry %d: got %v and %v with the same seed", strategy, i+1, got1[i], got2[i])
+			}
+		}
+	}
+}
+
+func TestStrategiesStopAfterMaxElapsedTime(t *testing.T) {
+	for _, strategy := range []Strategy{
+		ConstantBackoff(time.Minute),
+		LinearBackoff(time.Minute, 0, time.Minute),
+		FibonacciBackoff(time.Minute, time.Minute),
+		FullJitterBackoff(time.Minute, time.Minute),
+		EqualJitterBackoff(time.Minute, time.Minute),
+		DecorrelatedJitterBackoff(time.Minute, time.Minute),
+	} {
+		clock := &autoClock{now: time.Unix(0, 0)}
+		c := NewBackoffClient(WithBackoffStrategy(strategy), WithClock(clock))
+
+		b := newBackOff(context.Background(), &c.cfg)
+		retries := 0
+		for b.NextBackOff() != backoff.Stop {
+			retries++
+			if retries > 1000 {
+				t.Fatalf("%T: still retrying after %v", strategy, clock.Now().Sub(time.Unix(0, 0)))
+			}
+			clock.After(time.Minute)
+		}
+
+		if want := int(DefaultMaxElapsedTime/time.Minute) + 1; retries != want {
+			t.Errorf("%T: got %d retries, want %d", strategy, retries, want)
+		}
+	}
+}
+
+func TestExecuteStopsAfterMaxElapsedTime(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	clock := &autoClock{now: time.Unix(0, 0)}
+	c := NewBackoffClient(WithBackoffStrategy(ConstantBackoff(time.Minute)), WithClock(clock))
+
+	r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	if _, err := c.Execute(r); err == nil {
+		t.Fatal("got no error, want the last retryable error")
+	}
+
+	if got, want := int(hits.Load()), int(DefaultMaxElapsedTime/time.Minute)+2; got != want {
+		t.Errorf("got %d attempts, want %d", got, want)
+	}
+}
@@ -0,0 +1,237 @@
+package backoff
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSOption defines a functional option pattern for configuring tls.Config.
+type TLSOption interface {
+	apply(c *tls.Config) error
+}
+
+type tlsOptionFunc func(*tls.Config) error
+
+func (o tlsOptionFunc) apply(c *tls.Config) error {
+	return o(c)
+}
+
+// NewTLSConfig returns a tls.Config with TLS 1.2 as minimum version and the
+// given options applied.
+func NewTLSConfig(opts ...TLSOption) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	for _, opt := range opts {
+		if err := opt.apply(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// NewPooledTransportWithTLS returns a pooled transport, see NewPooledTransport,
+// using the given TLS configuration.
+func NewPooledTransportWithTLS(tlsConfig *tls.Config) *http.Transport {
+	cfg := defaultTransportConfig()
+	cfg.tlsConfig = tlsConfig
+	return newTransport(&cfg)
+}
+
+// WithClientCertificate loads a client certificate for mTLS. The certificate
+// and key files are reloaded when they rotate on disk. If a rotated pair
+// cannot be loaded, e.g. because only one of the files has been replaced
+// yet, the previous certificate keeps being used.
+func WithClientCertificate(certFile, keyFile string) TLSOption {
+	return tlsOptionFunc(func(c *tls.Config) error {
+		reloader := &certificateReloader{certFile: certFile, keyFile: keyFile}
+		if _, err := reloader.load(); err != nil {
+			return err
+		}
+
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return reloader.load()
+		}
+		return nil
+	})
+}
+
+// WithCertificate sets a static client certificate for mTLS.
+func WithCertificate(cert tls.Certificate) TLSOption {
+	return tlsOptionFunc(func(c *tls.Config) error {
+		c.Certificates = append(c.Certificates, cert)
+		return nil
+	})
+}
+
+// WithCAFile adds the PEM encoded certificates of a CA bundle to the trusted
+// roots. The system roots are not trusted anymore unless WithSystemCAs is
+// given first.
+func WithCAFile(caFile string) TLSOption {
+	return tlsOptionFunc(func(c *tls.Config) error {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("http-client: CA bundle: %w", err)
+		}
+
+		return appendCAs(c, data)
+	})
+}
+
+// WithCAPEM adds PEM encoded CA certificates to the trusted roots.
+func WithCAPEM(pem []byte) TLSOption {
+	return tlsOptionFunc(func(c *tls.Config) error {
+		return appendCAs(c, pem)
+	})
+}
+
+// WithSystemCAs adds the system certificate pool to the trusted roots.
+func WithSystemCAs() TLSOption {
+	return tlsOptionFunc(func(c *tls.Config) error {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return fmt.Errorf("http-client: system CA pool: %w", err)
+		}
+
+		if c.RootCAs != nil {
+			// A pool cannot be merged into another one.
+			return errors.New("http-client: WithSystemCAs must come before CA bundles")
+		}
+
+		c.RootCAs = pool
+		return nil
+	})
+}
+
+// WithMinTLSVersion sets the minimum TLS version, e.g. tls.VersionTLS13.
+func WithMinTLSVersion(version uint16) TLSOption {
+	return tlsOptionFunc(func(c *tls.Config) error {
+		c.MinVersion = version
+		return nil
+	})
+}
+
+// WithServerName overrides the server name used for SNI and certificate
+// verification.
+func WithServerName(serverName string) TLSOption {
+	return tlsOptionFunc(func(c *tls.Config) error {
+		c.ServerName = serverName
+		return nil
+	})
+}
+
+// WithPinnedSPKI only accepts connections whose verified certificate chain
+// contains a certificate with one of the given SPKI pins, the base64 encoded
+// SHA-256 of the certificate's SubjectPublicKeyInfo as computed by SPKIHash.
+// Certificates the server sent but that are not part of a verified chain are
+// never matched, so connections without chain verification, e.g. with
+// InsecureSkipVerify, are always rejected.
+func WithPinnedSPKI(pins ...string) TLSOption {
+	return tlsOptionFunc(func(c *tls.Config) error {
+		if len(pins) == 0 {
+			return errors.New("http-client: no SPKI pins given")
+		}
+
+		allowed := make(map[string]struct{}, len(pins))
+		for _, pin := range pins {
+			allowed[pin] = struct{}{}
+		}
+
+		verify := c.VerifyConnection
+		c.VerifyConnection = func(state tls.ConnectionState) error {
+			if verify != nil {
+				if err := verify(state); err != nil {
+					return err
+				}
+			}
+
+			for _, chain := range state.VerifiedChains {
+				for _, cert := range chain {
+					if _, ok := allowed[SPKIHash(cert)]; ok {
+						return nil
+					}
+				}
+			}
+
+			return errors.New("http-client: no certificate matches the pinned SPKI hashes")
+		}
+		return nil
+	})
+}
+
+// SPKIHash returns the base64 encoded SHA-256 of the certificate's
+// SubjectPublicKeyInfo, the format expected by WithPinnedSPKI.
+func SPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func appendCAs(c *tls.Config, pem []byte) error {
+	if c.RootCAs == nil {
+		c.RootCAs = x509.NewCertPool()
+	}
+
+	if !c.RootCAs.AppendCertsFromPEM(pem) {
+		return errors.New("http-client: no certificates found in CA bundle")
+	}
+
+	return nil
+}
+
+// certificateReloader loads a certificate and key pair and reloads it when
+// either file changes on disk.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex // guards the fields below
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+func (l *certificateReloader) load() (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	certInfo, err := os.Stat(l.certFile)
+	if err != nil {
+		return l.fallback(fmt.Errorf("http-client: client certificate: %w", err))
+	}
+
+	keyInfo, err := os.Stat(l.keyFile)
+	if err != nil {
+		return l.fallback(fmt.Errorf("http-client: client key: %w", err))
+	}
+
+	if l.cert != nil && certInfo.ModTime().Equal(l.certMod) && keyInfo.ModTime().Equal(l.keyMod) {
+		return l.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return l.fallback(fmt.Errorf("http-client: client certificate: %w", err))
+	}
+
+	l.cert, l.certMod, l.keyMod = &cert, certInfo.ModTime(), keyInfo.ModTime()
+	return l.cert, nil
+}
+
+// fallback returns the previously loaded certificate, if any, instead of err.
+func (l *certificateReloader) fallback(err error) (*tls.Certificate, error) {
+	if l.cert != nil {
+		return l.cert, nil
+	}
+
+	return nil, err
+}
@@ -0,0 +1,326 @@
+package backoff
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key := newTestKey(t)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a leaf certificate for commonName, valid for the given DNS
+// names, or 127.0.0.1 if there are none.
+func (ca *testCA) issue(t *testing.T, commonName string, dnsNames ...string) tls.Certificate {
+	t.Helper()
+
+	key := newTestKey(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     dnsNames,
+	}
+	if len(dnsNames) == 0 {
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// writeKeyPair writes cert and its key as PEM files to dir.
+func writeKeyPair(t *testing.T, dir string, cert tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	return certFile, keyFile
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTLSServer starts a TLS server presenting cert and answering with the
+// common name of the client certificate, if any.
+func newTLSServer(t *testing.T, cert tls.Certificate, configure func(*tls.Config)) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+		}
+	}))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0) // rejected handshakes are expected
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	if configure != nil {
+		configure(server.TLS)
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// getTLS sends a GET request to url through a transport using the TLS
+// configuration built from opts and returns the response body.
+func getTLS(t *testing.T, url string, opts ...TLSOption) (string, error) {
+	t.Helper()
+
+	tlsConfig, err := NewTLSConfig(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := NewPooledTransportWithTLS(tlsConfig)
+	defer transport.CloseIdleConnections()
+
+	resp, err := (&http.Client{Transport: transport}).Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body := make([]byte, 64)
+	n, _ := resp.Body.Read(body)
+	return string(body[:n]), nil
+}
+
+func TestTLSCAFile(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTLSServer(t, ca.issue(t, "server"), nil)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writeFile(t, caFile, ca.pem)
+
+	if _, err := getTLS(t, server.URL, WithCAFile(caFile)); err != nil {
+		t.Errorf("trusted CA: %v", err)
+	}
+
+	other := newTestCA(t)
+	if _, err := getTLS(t, server.URL, WithCAPEM(other.pem)); err == nil {
+		t.Error("untrusted CA: got no error")
+	}
+}
+
+func TestTLSServerName(t *testing.T) {
+	ca := newTestCA(t)
+
+	var serverName string
+	server := newTLSServer(t, ca.issue(t, "server", "api.example.test"), func(c *tls.Config) {
+		c.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, nil
+		}
+	})
+
+	if _, err := getTLS(t, server.URL, WithCAPEM(ca.pem), WithServerName("api.example.test")); err != nil {
+		t.Fatalf("with server name: %v", err)
+	}
+	if serverName != "api.example.test" {
+		t.Errorf("got SNI %q, want %q", serverName, "api.example.test")
+	}
+
+	if _, err := getTLS(t, server.URL, WithCAPEM(ca.pem)); err == nil {
+		t.Error("without server name: got no error")
+	}
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	server := newTLSServer(t, ca.issue(t, "server"), func(c *tls.Config) {
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+		c.ClientCAs = pool
+	})
+
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, ca.issue(t, "client-1"))
+
+	tlsConfig, err := NewTLSConfig(WithCAPEM(ca.pem), WithClientCertificate(certFile, keyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := NewPooledTransportWithTLS(tlsConfig)
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	get := func() string {
+		t.Helper()
+
+		// Every request needs a new handshake to pick up the certificate.
+		transport.CloseIdleConnections()
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		return string(body[:n])
+	}
+
+	if got := get(); got != "client-1" {
+		t.Fatalf("got client certificate %q, want %q", got, "client-1")
+	}
+
+	// Rotate the pair on disk, it is picked up by the next handshake.
+	writeKeyPair(t, dir, ca.issue(t, "client-2"))
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := get(); got != "client-2" {
+		t.Errorf("after rotation: got client certificate %q, want %q", got, "client-2")
+	}
+
+	// A broken rotation keeps the previous certificate.
+	writeFile(t, keyFile, []byte("garbage"))
+	later = later.Add(time.Minute)
+	if err := os.Chtimes(keyFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := get(); got != "client-2" {
+		t.Errorf("after broken rotation: got client certificate %q, want %q", got, "client-2")
+	}
+
+	if _, err := getTLS(t, server.URL, WithCAPEM(ca.pem)); err == nil {
+		t.Error("without client certificate: got no error")
+	}
+}
+
+func TestTLSPinnedSPKI(t *testing.T) {
+	ca := newTestCA(t)
+	leaf := ca.issue(t, "server")
+	server := newTLSServer(t, leaf, nil)
+
+	tests := []struct {
+		name string
+		pins []string
+		ok   bool
+	}{
+		{name: "leaf", pins: []string{SPKIHash(leaf.Leaf)}, ok: true},
+		{name: "CA", pins: []string{SPKIHash(ca.cert)}, ok: true},
+		{name: "one of several", pins: []string{SPKIHash(newTestCA(t).cert), SPKIHash(ca.cert)}, ok: true},
+		{name: "unknown", pins: []string{SPKIHash(newTestCA(t).cert)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := getTLS(t, server.URL, WithCAPEM(ca.pem), WithPinnedSPKI(tt.pins...))
+			if tt.ok && err != nil {
+				t.Errorf("got error %v, want none", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("got no error, want pin mismatch")
+			}
+		})
+	}
+}
+
+func TestTLSPinnedSPKIUnverifiedCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	leaf := ca.issue(t, "server")
+
+	// The server appends a certificate carrying the pinned key that is not
+	// part of the verified chain, it must not satisfy the pin.
+	pinned := newTestCA(t)
+	leaf.Certificate = append(leaf.Certificate, pinned.cert.Raw)
+	server := newTLSServer(t, leaf, nil)
+
+	if _, err := getTLS(t, server.URL, WithCAPEM(ca.pem), WithPinnedSPKI(SPKIHash(pinned.cert))); err == nil {
+		t.Error("got no error, want pin mismatch")
+	}
+
+	insecure := tlsOptionFunc(func(c *tls.Config) error {
+		c.InsecureSkipVerify = true
+		return nil
+	})
+	if _, err := getTLS(t, server.URL, insecure, WithPinnedSPKI(SPKIHash(leaf.Leaf))); err == nil {
+		t.Error("without verification: got no error, want pin mismatch")
+	}
+}
@@ -0,0 +1,269 @@
+package backoff
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	DefaultDialTimeout           = 30 * time.Second
+	DefaultKeepAlive             = 30 * time.Second
+	DefaultMaxIdleConns          = 100
+	DefaultIdleConnTimeout       = 90 * time.Second
+	DefaultTLSHandshakeTimeout   = 10 * time.Second
+	DefaultExpectContinueTimeout = 1 * time.Second
+)
+
+// DialContextFunc dials a network connection, see net.Dialer.DialContext.
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+type transportConfig struct {
+	// Dialer settings, ignored when dialContext is set.
+	dialTimeout time.Duration
+	keepAlive   time.Duration
+
+	// dialContext is a custom dialer.
+	dialContext DialContextFunc
+
+	// Connection pool settings.
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int
+	idleConnTimeout     time.Duration
+
+	// Timeouts.
+	tlsHandshakeTimeout   time.Duration
+	expectContinueTimeout time.Duration
+	responseHeaderTimeout time.Duration
+
+	// proxy selects the proxy for a request, nil means no proxy.
+	proxy func(*http.Request) (*url.URL, error)
+
+	// tlsConfig is the TLS client configuration.
+	tlsConfig *tls.Config
+
+	// HTTP/2 settings.
+	forceAttemptHTTP2     bool
+	http2ReadIdleTimeout  time.Duration
+	http2PingTimeout      time.Duration
+	http2WriteByteTimeout time.Duration
+}
+
+// TransportOption defines a functional option pattern for configuring NewTransport.
+type TransportOption interface {
+	apply(c *transportConfig)
+}
+
+type transportOptionFunc func(*transportConfig)
+
+func (o transportOptionFunc) apply(c *transportConfig) {
+	o(c)
+}
+
+// NewTransport returns a new http.Transport. Without options it has similar
+// default values to http.DefaultTransport, with a per-host idle pool sized
+// after GOMAXPROCS. Do not use this for transient transports as it can leak
+// file descriptors over time. Only use this for transports that will be
+// re-used for the same host(s).
+//
+// It returns an error if the HTTP/2 options cannot be applied, e.g. because
+// HTTP/2 is disabled with WithForceAttemptHTTP2.
+func NewTransport(opts ...TransportOption) (*http.Transport, error) {
+	cfg := defaultTransportConfig()
+	for _, opt := range opts {
+		opt.apply(&cfg)
+	}
+
+	transport := newTransport(&cfg)
+	if cfg.http2ReadIdleTimeout <= 0 && cfg.http2PingTimeout <= 0 && cfg.http2WriteByteTimeout <= 0 {
+		return transport, nil
+	}
+
+	if !cfg.forceAttemptHTTP2 {
+		return nil, errors.New("http-client: HTTP/2 options require HTTP/2 to be attempted")
+	}
+
+	h2, err := http2.ConfigureTransports(transport)
+	if err != nil {
+		return nil, fmt.Errorf("http-client: failed to configure HTTP/2: %w", err)
+	}
+
+	h2.ReadIdleTimeout = cfg.http2ReadIdleTimeout
+	h2.PingTimeout = cfg.http2PingTimeout
+	h2.WriteByteTimeout = cfg.http2WriteByteTimeout
+
+	return transport, nil
+}
+
+func defaultTransportConfig() transportConfig {
+	return transportConfig{
+		dialTimeout:           DefaultDialTimeout,
+		keepAlive:             DefaultKeepAlive,
+		maxIdleConns:          DefaultMaxIdleConns,
+		maxIdleConnsPerHost:   runtime.GOMAXPROCS(0) + 1,
+		idleConnTimeout:       DefaultIdleConnTimeout,
+		tlsHandshakeTimeout:   DefaultTLSHandshakeTimeout,
+		expectContinueTimeout: DefaultExpectContinueTimeout,
+		proxy:                 http.ProxyFromEnvironment,
+		forceAttemptHTTP2:     true,
+	}
+}
+
+// newTransport returns the http.Transport for cfg, without its HTTP/2 options.
+func newTransport(cfg *transportConfig) *http.Transport {
+	dialContext := cfg.dialContext
+	if dialContext == nil {
+		dialContext = (&net.Dialer{
+			Timeout:   cfg.dialTimeout,
+			KeepAlive: cfg.keepAlive,
+		}).DialContext
+	}
+
+	return &http.Transport{
+		Proxy:                 cfg.proxy,
+		DialContext:           dialContext,
+		MaxIdleConns:          cfg.maxIdleConns,
+		MaxIdleConnsPerHost:   cfg.maxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.maxConnsPerHost,
+		IdleConnTimeout:       cfg.idleConnTimeout,
+		TLSHandshakeTimeout:   cfg.tlsHandshakeTimeout,
+		ExpectContinueTimeout: cfg.expectContinueTimeout,
+		ResponseHeaderTimeout: cfg.responseHeaderTimeout,
+		TLSClientConfig:       cfg.tlsConfig.Clone(),
+		ForceAttemptHTTP2:     cfg.forceAttemptHTTP2,
+	}
+}
+
+// WithDialTimeout sets the maximum time to establish a connection.
+func WithDialTimeout(timeout time.Duration) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.dialTimeout = timeout
+	})
+}
+
+// WithKeepAlive sets the TCP keep-alive period, a negative value disables keep-alives.
+func WithKeepAlive(keepAlive time.Duration) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.keepAlive = keepAlive
+	})
+}
+
+// WithDialContext sets a custom dialer. WithDialTimeout and WithKeepAlive
+// have no effect with a custom dialer.
+func WithDialContext(dial DialContextFunc) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.dialContext = dial
+	})
+}
+
+// WithMaxIdleConns sets the maximum number of idle connections across all hosts.
+func WithMaxIdleConns(n int) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.maxIdleConns = n
+	})
+}
+
+// WithMaxIdleConnsPerHost sets the maximum number of idle connections per host.
+func WithMaxIdleConnsPerHost(n int) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.maxIdleConnsPerHost = n
+	})
+}
+
+// WithMaxConnsPerHost limits the total number of connections per host, zero means no limit.
+func WithMaxConnsPerHost(n int) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.maxConnsPerHost = n
+	})
+}
+
+// WithIdleConnTimeout sets how long an idle connection is kept in the pool.
+func WithIdleConnTimeout(timeout time.Duration) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.idleConnTimeout = timeout
+	})
+}
+
+// WithTLSHandshakeTimeout sets the maximum time to wait for a TLS handshake.
+func WithTLSHandshakeTimeout(timeout time.Duration) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.tlsHandshakeTimeout = timeout
+	})
+}
+
+// WithExpectContinueTimeout sets the time to wait for a server's first
+// response headers after sending "Expect: 100-continue".
+func WithExpectContinueTimeout(timeout time.Duration) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.expectContinueTimeout = timeout
+	})
+}
+
+// WithResponseHeaderTimeout sets the time to wait for the response headers
+// after the request has been written, zero means no timeout.
+func WithResponseHeaderTimeout(timeout time.Duration) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.responseHeaderTimeout = timeout
+	})
+}
+
+// WithProxy sets the function selecting the proxy for a request, nil disables
+// proxies. The default is http.ProxyFromEnvironment.
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.proxy = proxy
+	})
+}
+
+// WithProxyURL sends every request through the given proxy.
+func WithProxyURL(proxyURL *url.URL) TransportOption {
+	return WithProxy(http.ProxyURL(proxyURL))
+}
+
+// WithTLSConfig sets the TLS client configuration, see NewTLSConfig. The
+// transport uses a copy, tlsConfig is never modified.
+func WithTLSConfig(tlsConfig *tls.Config) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.tlsConfig = tlsConfig
+	})
+}
+
+// WithForceAttemptHTTP2 sets whether HTTP/2 is attempted, it is by default.
+func WithForceAttemptHTTP2(enabled bool) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.forceAttemptHTTP2 = enabled
+	})
+}
+
+// WithHTTP2ReadIdleTimeout sets after which time without frames a health
+// check ping is sent on an HTTP/2 connection, zero disables health checks.
+func WithHTTP2ReadIdleTimeout(timeout time.Duration) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.http2ReadIdleTimeout = timeout
+	})
+}
+
+// WithHTTP2PingTimeout sets after which time an HTTP/2 connection is closed
+// when a health check ping is not answered.
+func WithHTTP2PingTimeout(timeout time.Duration) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.http2PingTimeout = timeout
+	})
+}
+
+// WithHTTP2WriteByteTimeout sets after which time an HTTP/2 connection is
+// closed when no data can be written to it.
+func WithHTTP2WriteByteTimeout(timeout time.Duration) TransportOption {
+	return transportOptionFunc(func(c *transportConfig) {
+		c.http2WriteByteTimeout = timeout
+	})
+}
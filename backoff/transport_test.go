@@ -0,0 +1,65 @@
+package backoff
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewTransportDefaults(t *testing.T) {
+	transport, err := NewTransport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !transport.ForceAttemptHTTP2 || transport.MaxIdleConns != DefaultMaxIdleConns || transport.IdleConnTimeout != DefaultIdleConnTimeout {
+		t.Errorf("got transport %+v, want the defaults", transport)
+	}
+}
+
+func TestNewTransportHTTP2Options(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	tlsConfig := &tls.Config{RootCAs: roots}
+	transport, err := NewTransport(
+		WithTLSConfig(tlsConfig),
+		WithHTTP2ReadIdleTimeout(time.Second),
+		WithHTTP2PingTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.CloseIdleConnections()
+
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("got protocol %s, want HTTP/2", resp.Proto)
+	}
+
+	// Configuring HTTP/2 adds "h2" to the transport's copy only.
+	if len(tlsConfig.NextProtos) != 0 {
+		t.Errorf("got NextProtos %q, want the caller's config unchanged", tlsConfig.NextProtos)
+	}
+}
+
+func TestNewTransportHTTP2OptionsWithoutHTTP2(t *testing.T) {
+	if _, err := NewTransport(WithForceAttemptHTTP2(false), WithHTTP2PingTimeout(time.Second)); err == nil {
+		t.Error("got no error for HTTP/2 options with HTTP/2 disabled")
+	}
+}
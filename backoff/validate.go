@@ -0,0 +1,128 @@
+package backoff
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"slices"
+)
+
+// ResponseValidatorFunc checks a response that has an expected status code.
+// A non-nil error fails the request with a ValidationError.
+type ResponseValidatorFunc func(resp *Response) error
+
+// ValidationError is returned when a response validator, or a body retry
+// policy, rejects a response.
+type ValidationError struct {
+	Response *Response `json:"response,omitempty"`
+	Err      error     `json:"error,omitempty"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("http-client: invalid response: %v", e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// RequireContentType rejects responses whose Content-Type is none of mediaTypes.
+func RequireContentType(mediaTypes ...string) ResponseValidatorFunc {
+	return func(resp *Response) error {
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get(ContentTypeHeader))
+		for _, want := range mediaTypes {
+			if mediaType == want {
+				return nil
+			}
+		}
+		return fmt.Errorf("unexpected content type %q", mediaType)
+	}
+}
+
+// RequireJSONFields rejects responses that are not a JSON object with all of
+// the given top-level fields. Schema validation libraries can be plugged in
+// the same way with a custom ResponseValidatorFunc.
+func RequireJSONFields(fields ...string) ResponseValidatorFunc {
+	return func(resp *Response) error {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(resp.Body, &object); err != nil {
+			return err
+		}
+
+		for _, field := range fields {
+			if _, ok := object[field]; !ok {
+				return fmt.Errorf("missing field %q", field)
+			}
+		}
+		return nil
+	}
+}
+
+// validateResponse runs the validators of cfg on response.
+func validateResponse(response *Response, cfg *config) error {
+	for _, validate := range cfg.validators {
+		if err := validate(response); err != nil {
+			return &ValidationError{Response: response, Err: err}
+		}
+	}
+	return nil
+}
+
+// BodyRetryPolicyFunc looks at the body of a response with an expected status
+// code, e.g. for APIs reporting throttling in a 200. A *RetryableError retries
+// the request, any other non-nil error fails it with a ValidationError.
+type BodyRetryPolicyFunc func(resp *Response) error
+
+// DefaultBodyRetryPolicyLimit is the default size up to which bodies are
+// inspected by body retry policies.
+const DefaultBodyRetryPolicyLimit = 64 << 10
+
+// JSONRetryPolicy returns a body retry policy decoding JSON bodies into a T
+// and returning the error of check, see BodyRetryPolicyFunc. Bodies that are
+// not JSON are accepted.
+func JSONRetryPolicy[T any](check func(v *T) error) BodyRetryPolicyFunc {
+	return func(resp *Response) error {
+		v := new(T)
+		if err := json.Unmarshal(resp.Body, v); err != nil {
+			return nil
+		}
+		return check(v)
+	}
+}
+
+// RetryOnJSONField retries JSON object responses whose top-level field is a
+// string equal to one of values, or to anything if there are none, e.g.
+// RetryOnJSONField("error", "throttled").
+func RetryOnJSONField(field string, values ...string) BodyRetryPolicyFunc {
+	return JSONRetryPolicy(func(object *map[string]json.RawMessage) error {
+		raw, ok := (*object)[field]
+		if !ok {
+			return nil
+		}
+
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil
+		}
+
+		if len(values) > 0 && !slices.Contains(values, value) {
+			return nil
+		}
+
+		return &RetryableError{Err: fmt.Errorf("response %s %q", field, value)}
+	})
+}
+
+// checkBody runs the body retry policies of cfg on response.
+func checkBody(response *Response, cfg *config) error {
+	if int64(len(response.Body)) > cfg.bodyPolicyLimit {
+		return nil
+	}
+
+	for _, policy := range cfg.bodyPolicies {
+		if err := policy(response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package backoff
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// sequenceServer answers the n-th request with responses[n], repeating the
+// last one, and counts the requests.
+func sequenceServer(t *testing.T, responses ...func(w http.ResponseWriter)) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		responses[min(n, len(responses))-1](w)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// reply writes a JSON response with status and body.
+func reply(status int, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set(ContentTypeHeader, ContentTypeJSON)
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}
+}
+
+func TestResponseValidators(t *testing.T) {
+	valid := reply(http.StatusOK, `{"id":1,"name":"a"}`)
+	partial := reply(http.StatusOK, `{"id":1}`)
+	text := func(w http.ResponseWriter) {
+		w.Header().Set(ContentTypeHeader, "text/plain")
+		fmt.Fprint(w, `{"id":1,"name":"a"}`)
+	}
+
+	tests := []struct {
+		name      string
+		responses []func(w http.ResponseWriter)
+		opts      []Option
+		requests  int32
+		err       any
+	}{
+		{
+			name:      "valid",
+			responses: []func(w http.ResponseWriter){valid},
+			opts:      []Option{WithResponseValidator(RequireContentType(ContentTypeJSON), RequireJSONFields("id", "name"))},
+			requests:  1,
+		},
+		{
+			name:      "unexpected content type",
+			responses: []func(w http.ResponseWriter){text, valid},
+			opts:      []Option{WithResponseValidator(RequireContentType(ContentTypeJSON, ContentTypeXML))},
+			requests:  1,
+			err:       new(*ValidationError),
+		},
+		{
+			name:      "missing field",
+			responses: []func(w http.ResponseWriter){partial, valid},
+			opts:      []Option{WithResponseValidator(RequireJSONFields("id", "name"))},
+			requests:  1,
+			err:       new(*ValidationError),
+		},
+		{
+			name:      "not a JSON object",
+			responses: []func(w http.ResponseWriter){reply(http.StatusOK, `[1]`)},
+			opts:      []Option{WithResponseValidator(RequireJSONFields("id"))},
+			requests:  1,
+			err:       new(*ValidationError),
+		},
+		{
+			name:      "retried then valid",
+			responses: []func(w http.ResponseWriter){partial, valid},
+			opts:      []Option{WithResponseValidator(RequireJSONFields("name")), WithRetryOnInvalidResponse()},
+			requests:  2,
+		},
+		{
+			name:      "retries exhausted",
+			responses: []func(w http.ResponseWriter){partial},
+			opts:      []Option{WithResponseValidator(RequireJSONFields("name")), WithRetryOnInvalidResponse()},
+			requests:  3,
+			err:       new(*RetryableError),
+		},
+		{
+			name:      "only 2xx are retried",
+			responses: []func(w http.ResponseWriter){reply(http.StatusNotFound, `{}`), valid},
+			opts: []Option{
+				WithExpectedStatus(http.StatusOK, http.StatusNotFound),
+				WithResponseValidator(RequireJSONFields("name")),
+				WithRetryOnInvalidResponse(),
+			},
+			requests: 1,
+			err:      new(*ValidationError),
+		},
+		{
+			name:      "unexpected status is not validated",
+			responses: []func(w http.ResponseWriter){reply(http.StatusNotFound, `{}`)},
+			opts:      []Option{WithExpectedStatus(http.StatusOK), WithResponseValidator(RequireJSONFields("name"))},
+			requests:  1,
+			err:       new(*StatusError),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := sequenceServer(t, tt.responses...)
+
+			r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			_, err := retryingClient(tt.opts...).Execute(r)
+
+			switch {
+			case tt.err == nil && err != nil:
+				t.Errorf("got error %v", err)
+			case tt.err != nil && !errors.As(err, tt.err):
+				t.Errorf("got error %v, want %T", err, tt.err)
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("got %d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestExpectedStatusPerRequest(t *testing.T) {
+	server, _ := sequenceServer(t, reply(http.StatusCreated, `{}`))
+	c := retryingClient(WithExpectedStatus(http.StatusOK))
+
+	r, _ := http.NewRequest(http.MethodPost, server.URL, nil)
+	_, err := c.Execute(r)
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Response.StatusCode != http.StatusCreated {
+		t.Fatalf("got error %v, want a StatusError for 201", err)
+	}
+	if got, want := err.Error(), "http-client: unexpected status code 201 Created"; got != want {
+		t.Errorf("got error %q, want %q", got, want)
+	}
+
+	// Request options replace the client's expected status codes.
+	r, _ = http.NewRequest(http.MethodPost, server.URL, nil)
+	if _, err := c.Execute(r, WithExpectedStatus(http.StatusCreated, http.StatusNoContent)); err != nil {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestBodyRetryPolicy(t *testing.T) {
+	throttled := reply(http.StatusOK, `{"error":"throttled"}`)
+	invalid := reply(http.StatusOK, `{"error":"invalid_request"}`)
+	ok := reply(http.StatusOK, `{"id":1}`)
+
+	permanent := JSONRetryPolicy(func(v *struct{ Error string }) error {
+		if v.Error == "invalid_request" {
+			return errors.New(v.Error)
+		}
+		return nil
+	})
+
+	tests := []struct {
+		name      string
+		responses []func(w http.ResponseWriter)
+		opts      []Option
+		requests  int32
+		err       any
+	}{
+		{
+			name:      "retried then accepted",
+			responses: []func(w http.ResponseWriter){throttled, ok},
+			opts:      []Option{WithBodyRetryPolicy(RetryOnJSONField("error", "throttled"))},
+			requests:  2,
+		},
+		{
+			name:      "retries exhausted",
+			responses: []func(w http.ResponseWriter){throttled},
+			opts:      []Option{WithBodyRetryPolicy(RetryOnJSONField("error"))},
+			requests:  3,
+			err:       new(*RetryableError),
+		},
+		{
+			name:      "other values accepted",
+			responses: []func(w http.ResponseWriter){invalid},
+			opts:      []Option{WithBodyRetryPolicy(RetryOnJSONField("error", "throttled"))},
+			requests:  1,
+		},
+		{
+			name:      "permanent",
+			responses: []func(w http.ResponseWriter){invalid, ok},
+			opts:      []Option{WithBodyRetryPolicy(permanent)},
+			requests:  1,
+			err:       new(*ValidationError),
+		},
+		{
+			name:      "not JSON",
+			responses: []func(w http.ResponseWriter){reply(http.StatusOK, "throttled")},
+			opts:      []Option{WithBodyRetryPolicy(RetryOnJSONField("error"))},
+			requests:  1,
+		},
+		{
+			name:      "above the limit",
+			responses: []func(w http.ResponseWriter){throttled},
+			opts:      []Option{WithBodyRetryPolicy(RetryOnJSONField("error")), WithBodyRetryPolicyLimit(8)},
+			requests:  1,
+		},
+		{
+			name:      "unexpected status",
+			responses: []func(w http.ResponseWriter){reply(http.StatusNotFound, `{"error":"throttled"}`)},
+			opts:      []Option{WithBodyRetryPolicy(RetryOnJSONField("error")), WithExpectedStatus(http.StatusOK)},
+			requests:  1,
+			err:       new(*StatusError),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := sequenceServer(t, tt.responses...)
+
+			r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			_, err := retryingClient(tt.opts...).Execute(r)
+
+			switch {
+			case tt.err == nil && err != nil:
+				t.Errorf("got error %v", err)
+			case tt.err != nil && !errors.As(err, tt.err):
+				t.Errorf("got error %v, want %T", err, tt.err)
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("got %d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestBodyRetryPolicyErrorHasResponse(t *testing.T) {
+	server, _ := sequenceServer(t, reply(http.StatusOK, `{"error":"throttled"}`))
+
+	r, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	_, err := retryingClient(WithBodyRetryPolicy(RetryOnJSONField("error"))).Execute(r)
+
+	var retryable *RetryableError
+	if !errors.As(err, &retryable) || retryable.Response == nil || retryable.Response.StatusCode != http.StatusOK {
+		t.Fatalf("got error %v, want a RetryableError with the response", err)
+	}
+	if got, want := err.Error(), `response error "throttled"`; got != want {
+		t.Errorf("got error %q, want %q", got, want)
+	}
+}
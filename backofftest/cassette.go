@@ -0,0 +1,421 @@
+package backofftest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mseld/http-backoff/backoff"
+)
+
+// Mode selects whether a Cassette records or replays.
+type Mode int
+
+const (
+	// ModeAuto replays if the cassette file exists and records otherwise.
+	ModeAuto Mode = iota
+	// ModeRecord sends requests upstream and records them.
+	ModeRecord
+	// ModeReplay answers requests from the cassette file.
+	ModeReplay
+)
+
+// Redacted replaces redacted header and query values.
+const Redacted = "REDACTED"
+
+// ErrNoInteraction is returned by a strict Cassette for requests without a
+// recorded interaction.
+var ErrNoInteraction = errors.New("backofftest: no recorded interaction matches the request")
+
+// Interaction is a recorded request and its response. Request.Attempt counts
+// the requests recorded with the same method and URL, from 1.
+type Interaction struct {
+	Request  Request           `json:"request"`
+	Response *backoff.Response `json:"response"`
+}
+
+// Matcher reports whether a request matches a recorded one. body is the
+// request body.
+type Matcher func(r *http.Request, body []byte, recorded Request) bool
+
+// MatchMethod matches requests with the same method.
+func MatchMethod(r *http.Request, _ []byte, recorded Request) bool {
+	return r.Method == recorded.Method
+}
+
+// MatchURL matches requests with the same URL.
+func MatchURL(r *http.Request, _ []byte, recorded Request) bool {
+	return r.URL.String() == recorded.URL
+}
+
+// MatchBody matches requests with the same body.
+func MatchBody(_ *http.Request, body []byte, recorded Request) bool {
+	return bytes.Equal(body, recorded.Body)
+}
+
+// MatchHeaders matches requests with the same values for the given headers.
+func MatchHeaders(names ...string) Matcher {
+	return func(r *http.Request, _ []byte, recorded Request) bool {
+		for _, name := range names {
+			if fmt.Sprint(r.Header.Values(name)) != fmt.Sprint(recorded.Header.Values(name)) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchAll matches requests matched by all matchers.
+func MatchAll(matchers ...Matcher) Matcher {
+	return func(r *http.Request, body []byte, recorded Request) bool {
+		for _, match := range matchers {
+			if !match(r, body, recorded) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+type cassetteConfig struct {
+	mode          Mode
+	base          http.RoundTripper
+	matcher       Matcher
+	strict        bool
+	redactHeaders []string
+	redactQuery   []string
+	redact        func(*Interaction)
+}
+
+// CassetteOption defines a functional option pattern for configuring NewCassette.
+type CassetteOption interface {
+	apply(c *cassetteConfig)
+}
+
+type cassetteOptionFunc func(*cassetteConfig)
+
+func (o cassetteOptionFunc) apply(c *cassetteConfig) {
+	o(c)
+}
+
+// WithMode sets whether the cassette records or replays, ModeAuto by default.
+func WithMode(mode Mode) CassetteOption {
+	return cassetteOptionFunc(func(c *cassetteConfig) {
+		c.mode = mode
+	})
+}
+
+// WithTransport sets the transport requests are recorded from,
+// http.DefaultTransport by default. It also serves unmatched requests when
+// replaying without WithStrict.
+func WithTransport(base http.RoundTripper) CassetteOption {
+	return cassetteOptionFunc(func(c *cassetteConfig) {
+		c.base = base
+	})
+}
+
+// WithMatcher sets how requests are matched to recorded ones, by method and
+// URL by default.
+func WithMatcher(matcher Matcher) CassetteOption {
+	return cassetteOptionFunc(func(c *cassetteConfig) {
+		c.matcher = matcher
+	})
+}
+
+// WithStrict fails unmatched requests with ErrNoInteraction when replaying
+// instead of sending them upstream.
+func WithStrict() CassetteOption {
+	return cassetteOptionFunc(func(c *cassetteConfig) {
+		c.strict = true
+	})
+}
+
+// WithRedactedHeaders redacts the given request and response headers in
+// addition to Authorization, Proxy-Authorization, Cookie and Set-Cookie.
+// Redaction happens before the interaction is matched or saved.
+func WithRedactedHeaders(names ...string) CassetteOption {
+	return cassetteOptionFunc(func(c *cassetteConfig) {
+		c.redactHeaders = append(c.redactHeaders, names...)
+	})
+}
+
+// WithRedactedQuery redacts the given query parameters of request URLs.
+func WithRedactedQuery(params ...string) CassetteOption {
+	return cassetteOptionFunc(func(c *cassetteConfig) {
+		c.redactQuery = append(c.redactQuery, params...)
+	})
+}
+
+// WithRedactor sets a function redacting secrets, e.g. from bodies, in every
+// recorded interaction after the header and query redaction. When replaying
+// it also redacts a copy of each request before matching, with a nil
+// Response, so that matchers like MatchBody compare redacted bodies.
+func WithRedactor(redact func(*Interaction)) CassetteOption {
+	return cassetteOptionFunc(func(c *cassetteConfig) {
+		c.redact = redact
+	})
+}
+
+// Cassette is a RoundTripper recording interactions to a JSON file and
+// replaying them later, e.g.
+//
+//	cassette, err := NewCassette("testdata/orders.json")
+//	...
+//	defer cassette.Save()
+//	client := backoff.NewBackoffClient(backoff.WithClient(&http.Client{Transport: cassette}))
+//
+// The n-th request with a method and URL is answered by the matching
+// interaction recorded as its n-th attempt, so that retried and repeated
+// requests get the responses recorded for them. Once there is none the last
+// matching interaction is repeated.
+type Cassette struct {
+	path string
+	cfg  cassetteConfig
+
+	mu           sync.Mutex // guards the fields below
+	interactions []Interaction
+	attempts     map[string]int // requests per method and URL
+}
+
+// NewCassette returns a Cassette for the file at path, loading it when replaying.
+func NewCassette(path string, opts ...CassetteOption) (*Cassette, error) {
+	cfg := cassetteConfig{
+		base:          http.DefaultTransport,
+		matcher:       MatchAll(MatchMethod, MatchURL),
+		redactHeaders: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+	}
+
+	for _, opt := range opts {
+		opt.apply(&cfg)
+	}
+
+	if cfg.mode == ModeAuto {
+		cfg.mode = ModeRecord
+		if _, err := os.Stat(path); err == nil {
+			cfg.mode = ModeReplay
+		}
+	}
+
+	c := &Cassette{path: path, cfg: cfg, attempts: make(map[string]int)}
+	if cfg.mode == ModeRecord {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &c.interactions); err != nil {
+		return nil, fmt.Errorf("backofftest: cassette %s: %w", path, err)
+	}
+
+	return c, nil
+}
+
+// Interactions returns the recorded interactions.
+func (c *Cassette) Interactions() []Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Interaction(nil), c.interactions...)
+}
+
+// Save writes the recorded interactions to the cassette file. It does
+// nothing when replaying.
+func (c *Cassette) Save() error {
+	if c.cfg.mode != ModeRecord {
+		return nil
+	}
+
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(c.path, append(data, '\n'), 0o644)
+}
+
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	out, body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cfg.mode == ModeRecord {
+		return c.record(out, body)
+	}
+
+	if interaction, ok := c.match(req, body); ok {
+		return httpResponse(interaction.Response, req), nil
+	}
+
+	if c.cfg.strict {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, req.Method, req.URL)
+	}
+
+	return c.cfg.base.RoundTrip(out)
+}
+
+func (c *Cassette) record(req *http.Request, body []byte) (*http.Response, error) {
+	resp, err := c.cfg.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	interaction := Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: req.Header.Clone(),
+			Body:   body,
+		},
+		Response: &backoff.Response{
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header.Clone(),
+			Body:       respBody,
+		},
+	}
+	c.redactInteraction(&interaction)
+
+	c.mu.Lock()
+	interaction.Request.Attempt = c.nextAttempt(interaction.Request)
+	c.interactions = append(c.interactions, interaction)
+	c.mu.Unlock()
+
+	return resp, nil
+}
+
+// match returns the interaction matching req recorded with the same attempt
+// number, or else the last matching one.
+func (c *Cassette) match(req *http.Request, body []byte) (Interaction, bool) {
+	// Match against a redacted copy, recorded secrets are redacted too.
+	redacted := Interaction{Request: Request{Method: req.Method, URL: req.URL.String(), Header: req.Header.Clone(), Body: bytes.Clone(body)}}
+	c.redactInteraction(&redacted)
+
+	matchReq := req.Clone(req.Context())
+	matchReq.Header = redacted.Request.Header
+	if u, err := url.Parse(redacted.Request.URL); err == nil {
+		matchReq.URL = u
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	attempt := c.nextAttempt(redacted.Request)
+	last := -1
+	for i, interaction := range c.interactions {
+		if !c.cfg.matcher(matchReq, redacted.Request.Body, interaction.Request) {
+			continue
+		}
+		if interaction.Request.Attempt == attempt && sameRequestKey(interaction.Request, redacted.Request) {
+			return interaction, true
+		}
+		last = i
+	}
+
+	if last < 0 {
+		return Interaction{}, false
+	}
+
+	return c.interactions[last], true
+}
+
+// nextAttempt counts a request and returns its attempt number.
+func (c *Cassette) nextAttempt(r Request) int {
+	key := r.Method + " " + r.URL
+	c.attempts[key]++
+	return c.attempts[key]
+}
+
+func sameRequestKey(a, b Request) bool {
+	return a.Method == b.Method && a.URL == b.URL
+}
+
+func (c *Cassette) redactInteraction(interaction *Interaction) {
+	for _, name := range c.cfg.redactHeaders {
+		redactHeader(interaction.Request.Header, name)
+		if interaction.Response != nil {
+			redactHeader(interaction.Response.Header, name)
+		}
+	}
+
+	if len(c.cfg.redactQuery) > 0 {
+		if u, err := url.Parse(interaction.Request.URL); err == nil {
+			query := u.Query()
+			for _, param := range c.cfg.redactQuery {
+				if query.Has(param) {
+					query.Set(param, Redacted)
+				}
+			}
+			u.RawQuery = query.Encode()
+			interaction.Request.URL = u.String()
+		}
+	}
+
+	if c.cfg.redact != nil {
+		c.cfg.redact(interaction)
+	}
+}
+
+func redactHeader(header http.Header, name string) {
+	if values := header.Values(name); len(values) > 0 {
+		header.Set(name, Redacted)
+	}
+}
+
+// httpResponse returns resp as the response to req.
+func httpResponse(resp *backoff.Response, req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        resp.Status,
+		StatusCode:    resp.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        resp.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(resp.Body)),
+		ContentLength: int64(len(resp.Body)),
+		Request:       req,
+	}
+}
+
+// readRequestBody reads and closes the body of req. It returns the body and
+// a copy of req sending it again, req itself is not modified.
+func readRequestBody(req *http.Request) (*http.Request, []byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	out.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return out, body, nil
+}
@@ -0,0 +1,189 @@
+package backofftest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// trackedBody is a request body recording whether it was closed.
+type trackedBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestCassetteLeavesRequestUntouched(t *testing.T) {
+	server := NewServer(t, Respond(http.StatusOK, "recorded"))
+	cassette, err := NewCassette(filepath.Join(t.TempDir(), "cassette.json"), WithMode(ModeRecord))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := &trackedBody{Reader: strings.NewReader("payload")}
+	req, err := http.NewRequest(http.MethodPost, server.URL, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.GetBody = nil
+
+	resp, err := cassette.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if req.Body != body || req.GetBody != nil {
+		t.Error("RoundTrip replaced the body of the caller's request")
+	}
+	if !body.closed {
+		t.Error("RoundTrip did not close the request body")
+	}
+	if got := server.Requests()[0].Body; string(got) != "payload" {
+		t.Errorf("server got body %q, want %q", got, "payload")
+	}
+}
+
+func TestCassetteRedactsBodyBeforeMatching(t *testing.T) {
+	server := NewServer(t, Respond(http.StatusOK, "first"), Respond(http.StatusOK, "second"))
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	redactToken := WithRedactor(func(interaction *Interaction) {
+		if i := bytes.Index(interaction.Request.Body, []byte("token=")); i >= 0 {
+			interaction.Request.Body = append(interaction.Request.Body[:i:i], "token="+Redacted...)
+		}
+	})
+	matchBody := WithMatcher(MatchAll(MatchMethod, MatchURL, MatchBody))
+
+	post := func(cassette *Cassette, body string) (string, error) {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := cassette.RoundTrip(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		return string(data), err
+	}
+
+	recorder, err := NewCassette(path, WithMode(ModeRecord), redactToken, matchBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := post(recorder, "user=a&token=secret-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := post(recorder, "user=b&token=secret-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret-1")) {
+		t.Error("saved cassette contains the unredacted token")
+	}
+
+	player, err := NewCassette(path, WithMode(ModeReplay), WithStrict(), redactToken, matchBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other tokens match once redacted, the rest of the body still counts.
+	for body, want := range map[string]string{
+		"user=b&token=secret-2": "second",
+		"user=a&token=secret-3": "first",
+	} {
+		got, err := post(player, body)
+		if err != nil || got != want {
+			t.Errorf("replaying %q: got %q, %v, want %q", body, got, err, want)
+		}
+	}
+
+	if _, err := post(player, "user=c&token=secret-1"); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("got error %v, want ErrNoInteraction", err)
+	}
+	server.AssertRequestCount(t, 2)
+}
+
+func TestCassetteReplaysRepeatedRequests(t *testing.T) {
+	server := NewServer(t, Respond(http.StatusOK, "a1"), Respond(http.StatusOK, "b1"), Respond(http.StatusOK, "a2"))
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	get := func(cassette *Cassette, path string) string {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := cassette.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	recorder, err := NewCassette(path, WithMode(ModeRecord))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/a", "/b", "/a"} {
+		get(recorder, path)
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	var attempts []int
+	for _, interaction := range recorder.Interactions() {
+		attempts = append(attempts, interaction.Request.Attempt)
+	}
+	if fmt.Sprint(attempts) != "[1 1 2]" {
+		t.Errorf("got recorded attempts %v, want [1 1 2]", attempts)
+	}
+
+	player, err := NewCassette(path, WithMode(ModeReplay), WithStrict())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each request gets the response recorded for its attempt, whatever
+	// the order of the other requests, and then the last one.
+	var got []string
+	for _, path := range []string{"/b", "/a", "/b", "/a", "/a"} {
+		got = append(got, get(player, path))
+	}
+	if fmt.Sprint(got) != "[b1 a1 b1 a2 a2]" {
+		t.Errorf("got responses %v, want [b1 a1 b1 a2 a2]", got)
+	}
+	server.AssertRequestCount(t, 3)
+}
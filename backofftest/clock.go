@@ -0,0 +1,134 @@
+// Package backofftest provides helpers for testing code that uses
+// backoff.BackoffClient without waiting for real backoff intervals.
+package backofftest
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Clock is a fake backoff.Clock. Its time only moves when Advance or Set is
+// called, or, for clocks created with NewAutoClock, whenever the client waits.
+// Every wait is recorded, see Waits.
+type Clock struct {
+	mu     sync.Mutex // guards the fields below
+	cond   *sync.Cond
+	now    time.Time
+	auto   bool
+	timers []*timer
+	waits  []time.Duration
+}
+
+type timer struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+// NewClock returns a Clock starting at start that only moves on Advance or Set.
+func NewClock(start time.Time) *Clock {
+	c := &Clock{now: start}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// NewAutoClock returns a Clock starting at start that jumps to the end of
+// every wait right away, so that retry flows run instantly.
+func NewAutoClock(start time.Time) *Clock {
+	c := NewClock(start)
+	c.auto = true
+	return c
+}
+
+// Now returns the fake time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After records the wait and returns a channel receiving the fake time once
+// it has advanced by d.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.waits = append(c.waits, d)
+	t := &timer{deadline: c.now.Add(d), c: make(chan time.Time, 1)}
+	if c.auto && d > 0 {
+		c.now = t.deadline
+	}
+
+	if !t.deadline.After(c.now) {
+		t.c <- c.now
+	} else {
+		c.timers = append(c.timers, t)
+	}
+
+	c.cond.Broadcast()
+	return t.c
+}
+
+// Advance moves the fake time forward by d, firing the timers that expire.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(c.now.Add(d))
+}
+
+// Set moves the fake time to t, firing the timers that expire.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(t)
+}
+
+func (c *Clock) set(now time.Time) {
+	c.now = now
+
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.deadline.After(now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- now
+	}
+	c.timers = pending
+}
+
+// Waits returns the durations passed to After so far, that is the wait
+// schedule of the retries.
+func (c *Clock) Waits() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.waits...)
+}
+
+// BlockUntilWaits blocks until After has been called at least n times in
+// total, so that tests can Advance once the client is waiting.
+func (c *Clock) BlockUntilWaits(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waits) < n {
+		c.cond.Wait()
+	}
+}
+
+// AssertWaits fails the test unless the clock waited exactly the given durations.
+func (c *Clock) AssertWaits(t testing.TB, want ...time.Duration) {
+	t.Helper()
+
+	got := c.Waits()
+	if len(got) != len(want) {
+		t.Errorf("backofftest: got waits %v, want %v", got, want)
+		return
+	}
+
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("backofftest: got waits %v, want %v", got, want)
+			return
+		}
+	}
+}
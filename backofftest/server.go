@@ -0,0 +1,295 @@
+package backofftest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mseld/http-backoff/backoff"
+)
+
+// Step is one scripted reaction of a Server.
+type Step struct {
+	// Status is the response status code, 200 if zero.
+	Status int
+	Header http.Header
+	Body   []byte
+
+	// Delay is waited before responding, or until the request is cancelled.
+	Delay time.Duration
+
+	// Reset closes the connection without responding.
+	Reset bool
+
+	// Truncate announces the full Content-Length but closes the connection
+	// after half of Body.
+	Truncate bool
+}
+
+// Status returns a step responding with code and an empty body.
+func Status(code int) Step {
+	return Step{Status: code}
+}
+
+// Respond returns a step responding with code and body.
+func Respond(code int, body string) Step {
+	return Step{Status: code, Body: []byte(body)}
+}
+
+// RetryAfter returns a step responding with 503 and a Retry-After header of
+// the given number of seconds.
+func RetryAfter(seconds int) Step {
+	return Step{
+		Status: http.StatusServiceUnavailable,
+		Header: http.Header{"Retry-After": {strconv.Itoa(seconds)}},
+	}
+}
+
+// Reset returns a step closing the connection without responding.
+func Reset() Step {
+	return Step{Reset: true}
+}
+
+// Truncated returns a step responding with code and only the first half of body.
+func Truncated(code int, body string) Step {
+	return Step{Status: code, Body: []byte(body), Truncate: true}
+}
+
+// Delayed returns step responding after d.
+func Delayed(d time.Duration, step Step) Step {
+	step.Delay = d
+	return step
+}
+
+// ParseScript parses a comma separated script of steps, e.g.
+//
+//	503, 503, Retry-After 2, delay 100ms 200, reset, truncate
+//
+// A status code responds with an empty body, "Retry-After n" responds 503
+// with a Retry-After header, "reset" closes the connection and "truncate"
+// sends half of a 200 response. Any step may be prefixed with "delay d".
+func ParseScript(script string) ([]Step, error) {
+	var steps []Step
+	for _, token := range strings.Split(script, ",") {
+		fields := strings.Fields(token)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var delay time.Duration
+		if strings.EqualFold(fields[0], "delay") && len(fields) > 2 {
+			d, err := time.ParseDuration(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("backofftest: step %q: %w", token, err)
+			}
+			delay, fields = d, fields[2:]
+		}
+
+		var step Step
+		switch {
+		case len(fields) == 1 && strings.EqualFold(fields[0], "reset"):
+			step = Reset()
+		case len(fields) == 1 && strings.EqualFold(fields[0], "truncate"):
+			step = Truncated(http.StatusOK, "truncated response body")
+		case len(fields) == 2 && strings.EqualFold(fields[0], "retry-after"):
+			seconds, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("backofftest: step %q: %w", token, err)
+			}
+			step = RetryAfter(seconds)
+		case len(fields) == 1:
+			code, err := strconv.Atoi(fields[0])
+			if err != nil || code < 100 || code > 999 {
+				return nil, fmt.Errorf("backofftest: step %q: invalid status code", token)
+			}
+			step = Status(code)
+		default:
+			return nil, fmt.Errorf("backofftest: step %q: unknown step", token)
+		}
+
+		steps = append(steps, Delayed(delay, step))
+	}
+
+	if len(steps) == 0 {
+		return nil, errors.New("backofftest: empty script")
+	}
+
+	return steps, nil
+}
+
+// Request is a request received by a Server or recorded by a Cassette.
+type Request struct {
+	// Attempt counts the requests received by the server, from 1.
+	Attempt int         `json:"attempt"`
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Header  http.Header `json:"header,omitempty"`
+	Body    []byte      `json:"body,omitempty"`
+}
+
+// Server is an httptest.Server answering requests with the steps of a
+// script in order, the last step being repeated once the script is done.
+// It records every request it receives.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex // guards the fields below
+	steps    []Step
+	requests []Request
+}
+
+// NewServer starts a Server answering with steps, 200 if there are none. It
+// is closed when the test ends.
+func NewServer(t testing.TB, steps ...Step) *Server {
+	t.Helper()
+
+	if len(steps) == 0 {
+		steps = []Step{Status(http.StatusOK)}
+	}
+
+	s := &Server{steps: steps}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// NewScriptServer starts a Server answering with the steps of script, see
+// ParseScript. It is closed when the test ends.
+func NewScriptServer(t testing.TB, script string) *Server {
+	t.Helper()
+
+	steps, err := ParseScript(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewServer(t, steps...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	attempt := len(s.requests) + 1
+	s.requests = append(s.requests, Request{
+		Attempt: attempt,
+		Method:  r.Method,
+		URL:     r.URL.RequestURI(),
+		Header:  r.Header.Clone(),
+		Body:    body,
+	})
+	step := s.steps[min(attempt, len(s.steps))-1]
+	s.mu.Unlock()
+
+	if step.Delay > 0 {
+		select {
+		case <-time.After(step.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if step.Reset {
+		panic(http.ErrAbortHandler)
+	}
+
+	for key, values := range step.Header {
+		w.Header()[key] = values
+	}
+
+	status := step.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if !step.Truncate {
+		w.WriteHeader(status)
+		w.Write(step.Body)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(step.Body)))
+	w.WriteHeader(status)
+	w.Write(step.Body[:len(step.Body)/2])
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// AssertRequestCount fails the test unless the server received n requests.
+func (s *Server) AssertRequestCount(t testing.TB, n int) {
+	t.Helper()
+
+	if got := len(s.Requests()); got != n {
+		t.Errorf("backofftest: got %d requests, want %d", got, n)
+	}
+}
+
+// AssertReplayedBodies fails the test unless every retry sent the body of
+// the first request.
+func (s *Server) AssertReplayedBodies(t testing.TB) {
+	t.Helper()
+
+	requests := s.Requests()
+	for _, r := range requests[min(1, len(requests)):] {
+		if !bytes.Equal(r.Body, requests[0].Body) {
+			t.Errorf("backofftest: attempt %d sent body %q, want %q", r.Attempt, r.Body, requests[0].Body)
+		}
+	}
+}
+
+// AssertHeader fails the test unless every request had header key set to value.
+func (s *Server) AssertHeader(t testing.TB, key, value string) {
+	t.Helper()
+
+	for _, r := range s.Requests() {
+		if got := r.Header.Get(key); got != value {
+			t.Errorf("backofftest: attempt %d sent %s %q, want %q", r.Attempt, key, got, value)
+		}
+	}
+}
+
+// AssertStatus fails the test unless a BackoffClient call ended with a
+// response of the given status code, either returned, wrapped in a
+// backoff.StatusError or in the backoff.RetryableError of the last attempt.
+func AssertStatus(t testing.TB, resp *backoff.Response, err error, code int) {
+	t.Helper()
+
+	got := 0
+	var statusErr *backoff.StatusError
+	var retryableErr *backoff.RetryableError
+	switch {
+	case errors.As(err, &statusErr):
+		got = statusErr.Response.StatusCode
+	case errors.As(err, &retryableErr) && retryableErr.Response != nil:
+		got = retryableErr.Response.StatusCode
+	case err != nil:
+		t.Errorf("backofftest: got error %v, want status %d", err, code)
+		return
+	case resp == nil:
+		t.Errorf("backofftest: got no response, want status %d", code)
+		return
+	default:
+		got = resp.StatusCode
+	}
+
+	if got != code {
+		t.Errorf("backofftest: got status %d, want %d", got, code)
+	}
+}
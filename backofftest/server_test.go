@@ -0,0 +1,159 @@
+package backofftest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mseld/http-backoff/backoff"
+)
+
+func TestScriptServerRetryFlow(t *testing.T) {
+	// The client does not read Retry-After, the second retry waits the
+	// backoff interval and not 30s.
+	server := NewScriptServer(t, "503, Retry-After 30, 200")
+	clock := NewAutoClock(time.Unix(0, 0))
+
+	c := backoff.NewBackoffClient(
+		backoff.WithClock(clock),
+		backoff.WithBackoffStrategy(backoff.ConstantBackoff(time.Second)),
+		backoff.WithMaxRetry(5),
+	)
+
+	r, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("X-Test", "retry")
+
+	resp, err := c.Execute(r)
+	AssertStatus(t, resp, err, http.StatusOK)
+	server.AssertRequestCount(t, 3)
+	server.AssertReplayedBodies(t)
+	server.AssertHeader(t, "X-Test", "retry")
+	clock.AssertWaits(t, time.Second, time.Second)
+
+	if got := clock.Now(); !got.Equal(time.Unix(2, 0)) {
+		t.Errorf("got clock at %v, want it advanced by the waits", got)
+	}
+}
+
+func TestScriptServerConnectionFailures(t *testing.T) {
+	server := NewScriptServer(t, "reset, truncate, 200")
+	clock := NewAutoClock(time.Unix(0, 0))
+
+	c := backoff.NewBackoffClient(
+		backoff.WithClock(clock),
+		backoff.WithBackoffStrategy(backoff.ConstantBackoff(time.Second)),
+		backoff.WithMaxRetry(5),
+		backoff.WithErrorRetryPolicy(func(error) bool { return true }),
+	)
+
+	r, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Execute(r)
+	AssertStatus(t, resp, err, http.StatusOK)
+	server.AssertRequestCount(t, 3)
+	clock.AssertWaits(t, time.Second, time.Second)
+}
+
+func TestScriptServerGivesUp(t *testing.T) {
+	server := NewScriptServer(t, "503")
+	clock := NewAutoClock(time.Unix(0, 0))
+
+	c := backoff.NewBackoffClient(
+		backoff.WithClock(clock),
+		backoff.WithBackoffStrategy(backoff.ConstantBackoff(time.Second)),
+		backoff.WithMaxRetry(3),
+	)
+
+	r, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Execute(r)
+	AssertStatus(t, resp, err, http.StatusServiceUnavailable)
+	server.AssertRequestCount(t, 4)
+	clock.AssertWaits(t, time.Second, time.Second, time.Second)
+}
+
+func TestManualClockHoldsRetry(t *testing.T) {
+	server := NewScriptServer(t, "503, 200")
+	clock := NewClock(time.Unix(0, 0))
+
+	c := backoff.NewBackoffClient(
+		backoff.WithClock(clock),
+		backoff.WithBackoffStrategy(backoff.ConstantBackoff(time.Second)),
+		backoff.WithMaxRetry(3),
+	)
+
+	r, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		resp *backoff.Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := c.Execute(r)
+		done <- result{resp, err}
+	}()
+
+	// The retry waits on the clock until it is advanced.
+	clock.BlockUntilWaits(1)
+	server.AssertRequestCount(t, 1)
+
+	clock.Advance(time.Second)
+	res := <-done
+	AssertStatus(t, res.resp, res.err, http.StatusOK)
+	server.AssertRequestCount(t, 2)
+	clock.AssertWaits(t, time.Second)
+}
+
+func TestParseScript(t *testing.T) {
+	steps, err := ParseScript("503, Retry-After 2, delay 100ms 200, reset, truncate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Step{
+		Status(http.StatusServiceUnavailable),
+		RetryAfter(2),
+		Delayed(100*time.Millisecond, Status(http.StatusOK)),
+		Reset(),
+		Truncated(http.StatusOK, "truncated response body"),
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("got %d steps, want %d", len(steps), len(want))
+	}
+	for i := range want {
+		got, want := steps[i], want[i]
+		if got.Status != want.Status || got.Delay != want.Delay || got.Reset != want.Reset ||
+			got.Truncate != want.Truncate || string(got.Body) != string(want.Body) ||
+			got.Header.Get("Retry-After") != want.Header.Get("Retry-After") {
+			t.Errorf("step %d: got %+v, want %+v", i, got, want)
+		}
+	}
+
+	for _, script := range []string{
+		"",
+		" , ",
+		"42",
+		"ok",
+		"Retry-After soon",
+		"delay forever 200",
+		"reset now",
+	} {
+		if _, err := ParseScript(script); err == nil {
+			t.Errorf("ParseScript(%q): got no error", script)
+		}
+	}
+}
@@ -3,12 +3,18 @@ module github.com/mseld/http-backoff
 go 1.22
 
 require (
+	github.com/andybalholm/brotli v1.1.1
 	github.com/cenkalti/backoff/v4 v4.3.0
+	github.com/klauspost/compress v1.18.0
 	go.opentelemetry.io/contrib/instrumentation/net/http/httptrace/otelhttptrace v0.55.0
 	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.55.0
 	go.opentelemetry.io/otel v1.30.0
 	go.opentelemetry.io/otel/trace v1.30.0
+	golang.org/x/net v0.29.0
 	golang.org/x/oauth2 v0.23.0
+	golang.org/x/time v0.6.0
+	google.golang.org/protobuf v1.34.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -16,4 +22,5 @@ require (
 	github.com/go-logr/logr v1.4.2 // indirect
 	github.com/go-logr/stdr v1.2.2 // indirect
 	go.opentelemetry.io/otel/metric v1.30.0 // indirect
+	golang.org/x/text v0.18.0 // indirect
 )